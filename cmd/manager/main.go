@@ -20,6 +20,7 @@ import (
 	"github.com/operator-framework/operator-sdk/pkg/restmapper"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -32,6 +33,21 @@ var (
 	metricsHost       = "0.0.0.0"
 	metricsPort int32 = 8383
 )
+
+var (
+	workers     = pflag.Int("workers", trigger.DefaultWorkers, "Number of trigger rules processed concurrently")
+	batchWindow = pflag.Duration("batch-window", trigger.DefaultBatchWindow, "Time to collect updates of a workload from different rules into one rollout")
+
+	maxRollouts             = pflag.Int("max-rollouts", 0, "Maximum number of triggered rollouts in progress, 0 means no limit")
+	maxRolloutsPerNamespace = pflag.Int("max-rollouts-per-namespace", 0, "Maximum number of triggered rollouts in progress in a namespace, 0 means no limit")
+	maxRolloutsPerNodePool  = pflag.Int("max-rollouts-per-node-pool", 0, "Maximum number of triggered rollouts in progress on a node pool, 0 means no limit")
+	nodePoolLabel           = pflag.String("node-pool-label", "", "Label of nodes naming their node pool, required by --max-rollouts-per-node-pool")
+	rolloutJitter           = pflag.Duration("rollout-jitter", 0, "Maximum random delay before starting a triggered rollout")
+
+	drainTimeout = pflag.Duration("drain-timeout", trigger.DefaultDrainTimeout, "Time trigger rules in progress are given to finish on shutdown, before they are handed off to the next leader")
+
+	trustedNamespaces = pflag.StringSlice("trusted-namespaces", nil, "Namespaces whose trigger rules may access other namespaces and cluster-scoped objects, \"*\" trusts all namespaces")
+)
 var log = logf.Log.WithName("cmd")
 
 func printVersion() {
@@ -122,13 +138,30 @@ func main() {
 
 	// Setup trigger.
 	kc := kubernetes.NewForConfigOrDie(mgr.GetConfig())
-	trigger.Init(kc, log.WithName("trigger"))
-	defer trigger.Stop()
+	dc := dynamic.NewForConfigOrDie(mgr.GetConfig())
+	trigger.Init(mgr.GetConfig(), kc, dc, mgr.GetRESTMapper(), log.WithName("trigger"), trigger.Options{
+		Workers:     *workers,
+		BatchWindow: *batchWindow,
+
+		MaxRollouts:             *maxRollouts,
+		MaxRolloutsPerNamespace: *maxRolloutsPerNamespace,
+		MaxRolloutsPerNodePool:  *maxRolloutsPerNodePool,
+		NodePoolLabel:           *nodePoolLabel,
+		RolloutJitter:           *rolloutJitter,
+
+		DrainTimeout: *drainTimeout,
+
+		TrustedNamespaces: *trustedNamespaces,
+	})
 
 	log.Info("Starting the Cmd.")
 
 	// Start the Cmd
-	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
+	err = mgr.Start(signals.SetupSignalHandler())
+
+	// Controllers have stopped, drain the trigger before exit.
+	trigger.Stop()
+	if err != nil {
 		log.Error(err, "Manager exited non-zero")
 		os.Exit(1)
 	}
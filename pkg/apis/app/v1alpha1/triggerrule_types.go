@@ -1,8 +1,10 @@
 package v1alpha1
 
 import (
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -16,6 +18,41 @@ type TriggerRuleSpec struct {
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 	Sources []Source `json:"sources,omitempty"`
 	Actions []Action `json:"actions,omitempty"`
+	// PreActions are run in order before actions, actions are only executed if all of them succeeded.
+	PreActions []Hook `json:"preActions,omitempty"`
+	// PostActions are run in order after all actions succeeded.
+	PostActions []Hook `json:"postActions,omitempty"`
+	// Discover finds workloads consuming sources, so they do not need to be listed in actions.
+	Discover *Discovery `json:"discover,omitempty"`
+	// Debounce is the quiet period after the last change of sources before actions are executed, so a burst of
+	// changes causes a single execution.
+	Debounce *metav1.Duration `json:"debounce,omitempty"`
+	// MaxWait bounds the delay caused by Debounce since the first change of a burst. No limit if not set.
+	MaxWait *metav1.Duration `json:"maxWait,omitempty"`
+	// Priority of the rule, rules with higher priority are processed first, and their rollouts are admitted first
+	// by the rollout budget. Defaults to 0, negative values are allowed.
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// DiscoveryMode is the action applied to discovered workloads.
+type DiscoveryMode string
+
+const (
+	// DiscoveryAuto is the same as ActionAuto.
+	DiscoveryAuto DiscoveryMode = "Auto"
+	// DiscoveryRestart is the same as ActionUpdatePodTemplate.
+	DiscoveryRestart DiscoveryMode = "Restart"
+)
+
+// Discovery finds workloads in namespaces of sources which reference any source through volumes, projected
+// volumes, env valueFrom or envFrom. Workloads are discovered again whenever they are created or changed.
+type Discovery struct {
+	// Kinds of workloads to discover. Defaults to Deployment, StatefulSet and DaemonSet.
+	Kinds []string `json:"kinds,omitempty"`
+	// Selector limits discovered workloads by labels.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Mode is one of Auto and Restart. Defaults to Auto.
+	Mode DiscoveryMode `json:"mode,omitempty"`
 }
 
 // Source describes the resource that can be watched for updates.
@@ -29,10 +66,359 @@ type Source struct {
 type Action struct {
 	// UpdatePodTemplate will trigger workload rolling update by updating a special annotation of pod template.
 	UpdatePodTemplate *ActionUpdatePodTemplate `json:"updatePodTemplate,omitempty"`
+	// RunJob will run a Job again, since pod template of Job is immutable and can not be updated.
+	RunJob *ActionRunJob `json:"runJob,omitempty"`
+	// Scale will set replicas of a workload through its scale subresource.
+	Scale *ActionScale `json:"scale,omitempty"`
+	// Patch will patch any object with a patch rendered from sources.
+	Patch *ActionPatch `json:"patch,omitempty"`
+	// Apply will render manifests from sources and apply them with server-side apply.
+	Apply *ActionApply `json:"apply,omitempty"`
+	// Flux will request Flux to reconcile an object immediately.
+	Flux *ActionFlux `json:"flux,omitempty"`
+	// ArgoCD will refresh or sync an Argo CD Application.
+	ArgoCD *ActionArgoCD `json:"argoCD,omitempty"`
+	// VersionedCopy will point the workload to immutable copies of sources, so every change is a new revision.
+	VersionedCopy *ActionVersionedCopy `json:"versionedCopy,omitempty"`
+	// Replicate will keep copies of sources in namespaces matching a selector.
+	Replicate *ActionReplicate `json:"replicate,omitempty"`
+	// Render will render templates over sources and write the results to a ConfigMap or Secret.
+	Render *ActionRender `json:"render,omitempty"`
+	// RefreshVolumes will make kubelet refresh mounted ConfigMaps and Secrets of pods without restarting them.
+	RefreshVolumes *ActionRefreshVolumes `json:"refreshVolumes,omitempty"`
+	// Auto will choose between UpdatePodTemplate and RefreshVolumes by how the workload consumes sources.
+	Auto *ActionAuto `json:"auto,omitempty"`
+
+	// Retry is the retry policy of the action when it failed.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// RetryPolicy describes how a failed action is retried. Only transient errors, e.g. conflicts, timeouts of the API
+// server and throttling, are retried. An action failed with other errors, or failed MaxAttempts times, is exhausted and will
+// not run again until sources changed or a retry is requested with the annotation "trigger.app.example.com/retry".
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one. Defaults to 5.
+	MaxAttempts *int32 `json:"maxAttempts,omitempty"`
+	// BackoffSeconds is the delay before the first retry, it doubles for each following retry. Defaults to 5.
+	BackoffSeconds *int64 `json:"backoffSeconds,omitempty"`
+	// MaxBackoffSeconds is the maximum delay between retries. Defaults to 300.
+	MaxBackoffSeconds *int64 `json:"maxBackoffSeconds,omitempty"`
 }
 
 type ActionUpdatePodTemplate struct {
+	// ObjectRef specifies the workload. Any kind that embeds a pod template can be used, APIVersion is required
+	// for kinds other than the builtin workloads (Deployment, StatefulSet, DaemonSet, ReplicaSet and CronJob).
+	ObjectRef corev1.ObjectReference `json:"objectRef,omitempty"`
+	// TemplatePath is the path of pod template in the workload, e.g. "spec.template" or "{.spec.jobTemplate.spec.template}".
+	// Defaults to the well-known path of the workload kind, or "spec.template" for unknown kinds.
+	TemplatePath string `json:"templatePath,omitempty"`
+	// Surge temporarily raises replicas and maxSurge of the workload during the rollout, they are restored after
+	// the rollout completed so the rollout will not reduce serving capacity.
+	Surge *Surge `json:"surge,omitempty"`
+	// Selector selects workloads instead of ObjectRef. Each selected workload is updated with its own record, and
+	// results are listed in targets of the action status.
+	Selector *TargetSelector `json:"selector,omitempty"`
+	// Cooldown is the minimum interval between rollouts of a workload triggered by any rule. Changes arriving
+	// during the cooldown are applied together when it expires.
+	Cooldown *metav1.Duration `json:"cooldown,omitempty"`
+}
+
+// TargetSelector selects workloads by kinds and labels.
+type TargetSelector struct {
+	// Kinds of workloads. Defaults to Deployment, StatefulSet and DaemonSet.
+	Kinds []string `json:"kinds,omitempty"`
+	// Selector selects workloads by labels. All workloads of the kinds are selected if not set.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// NamespaceSelector selects namespaces of workloads. Defaults to the namespace of the rule.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// Surge describes how to raise capacity of a workload during a rollout.
+type Surge struct {
+	// Replicas is the number of replicas added during the rollout, workload must have scale subresource.
+	Replicas int32 `json:"replicas,omitempty"`
+	// MaxSurge overrides spec.strategy.rollingUpdate.maxSurge during the rollout.
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// TimeoutSeconds is the maximum time to wait for the rollout before restoring. Defaults to 600.
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// ActionScale sets replicas of any workload which has scale subresource.
+// Exactly one of Replicas, Delta and Restore should be set.
+type ActionScale struct {
+	ObjectRef corev1.ObjectReference `json:"objectRef,omitempty"`
+	// Replicas is the absolute number of replicas.
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Delta is added to the current replicas, can be negative.
+	Delta *int32 `json:"delta,omitempty"`
+	// Restore sets replicas to the value before the workload was scaled by kube-trigger.
+	Restore bool `json:"restore,omitempty"`
+}
+
+// JobNamingPolicy describes how to name the Jobs created by RunJob.
+type JobNamingPolicy string
+
+const (
+	// JobNamingRecreate deletes the previous Job and creates a new one with the same name.
+	JobNamingRecreate JobNamingPolicy = "Recreate"
+	// JobNamingHashSuffix creates a new Job named with a suffix computed from versions of sources.
+	JobNamingHashSuffix JobNamingPolicy = "HashSuffix"
+)
+
+// ActionRunJob runs a Job when sources changed. Exactly one of JobRef, CronJobRef and Template should be set.
+type ActionRunJob struct {
+	// JobRef references an existing Job, the Job will be run again with the same spec.
+	JobRef *corev1.ObjectReference `json:"jobRef,omitempty"`
+	// CronJobRef references a CronJob, a new Job will be created from its jobTemplate.
+	CronJobRef *corev1.ObjectReference `json:"cronJobRef,omitempty"`
+	// Template is used to create a new Job in namespace of the TriggerRule.
+	Template *batchv1beta1.JobTemplateSpec `json:"template,omitempty"`
+	// Name is the name or name prefix of created Jobs. Defaults to name of the referenced object,
+	// or "<name of the TriggerRule>-<index of the action>" when Template is used.
+	Name string `json:"name,omitempty"`
+	// NamingPolicy defaults to Recreate for JobRef, and HashSuffix otherwise.
+	NamingPolicy JobNamingPolicy `json:"namingPolicy,omitempty"`
+	// HistoryLimit is the number of finished Jobs to keep when NamingPolicy is HashSuffix. Defaults to 3.
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+	// WaitForCompletion waits until the Job completes or fails, and records the result in status.
+	WaitForCompletion bool `json:"waitForCompletion,omitempty"`
+	// TimeoutSeconds is the maximum time to wait for the Job. Defaults to 600.
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// Hook runs a Job created from Template in namespace of the TriggerRule and waits for its completion.
+// The Job is only created once for each version of sources.
+type Hook struct {
+	// Name is the name prefix of created Jobs, defaults to "<name of the TriggerRule>-<phase>-<index of the hook>",
+	// e.g. "foo-pre-0".
+	Name     string                       `json:"name,omitempty"`
+	Template batchv1beta1.JobTemplateSpec `json:"template"`
+	// TimeoutSeconds is the maximum time to wait for the Job. Defaults to 600.
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// PatchType is the type of patch used by ActionPatch.
+type PatchType string
+
+const (
+	JSONPatchType           PatchType = "json"
+	MergePatchType          PatchType = "merge"
+	StrategicMergePatchType PatchType = "strategic"
+)
+
+// ActionPatch patches an object of any kind.
+type ActionPatch struct {
+	// ObjectRef specifies the object, APIVersion is required for kinds other than the builtin workloads.
+	ObjectRef corev1.ObjectReference `json:"objectRef,omitempty"`
+	// Type is the type of patch, one of json, merge and strategic. Defaults to merge.
+	Type PatchType `json:"type,omitempty"`
+	// Patch is a Go template rendered to a patch in JSON or YAML. Data of sources and the record can be
+	// referenced in the template, e.g. {{ (.Source "cm").Data.version }} or {{ .Hash }}.
+	Patch string `json:"patch"`
+}
+
+// ActionApply applies manifests with server-side apply. Exactly one of Manifests and ManifestsFrom should be set.
+type ActionApply struct {
+	// Manifests is a Go template rendered to one or more YAML documents, data available in the template is
+	// the same as ActionPatch.
+	Manifests string `json:"manifests,omitempty"`
+	// ManifestsFrom references a ConfigMap, templates in all keys are rendered in order of keys.
+	// Add the ConfigMap to sources to apply again when templates changed.
+	ManifestsFrom *corev1.ObjectReference `json:"manifestsFrom,omitempty"`
+	// Namespace is used for namespaced objects without namespace. Defaults to namespace of the TriggerRule.
+	Namespace string `json:"namespace,omitempty"`
+	// Name identifies objects applied by this action, they are labeled with "trigger.app.example.com/apply-name",
+	// so they are still owned by this action when actions are reordered. Required by Prune.
+	Name string `json:"name,omitempty"`
+	// Prune deletes objects applied by this action before but not in manifests any more. Only namespaces of
+	// objects recorded in status of the rule are searched.
+	Prune bool `json:"prune,omitempty"`
+}
+
+// ActionFlux requests reconciliation of a Flux object, e.g. Kustomization, HelmRelease or GitRepository, by
+// setting annotation reconcile.fluxcd.io/requestedAt.
+type ActionFlux struct {
+	// ObjectRef specifies the Flux object, group is inferred from kind if APIVersion is not set.
+	ObjectRef corev1.ObjectReference `json:"objectRef,omitempty"`
+	// WaitForReady waits until the request is handled and records the Ready condition in status.
+	WaitForReady bool `json:"waitForReady,omitempty"`
+	// TimeoutSeconds is the maximum time to wait. Defaults to 600.
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// ArgoCDRefreshType is the type of refresh of an Argo CD Application.
+type ArgoCDRefreshType string
+
+const (
+	ArgoCDRefreshNormal ArgoCDRefreshType = "normal"
+	ArgoCDRefreshHard   ArgoCDRefreshType = "hard"
+)
+
+// ActionArgoCD refreshes and optionally syncs an Argo CD Application.
+type ActionArgoCD struct {
+	// ObjectRef specifies the Application.
 	ObjectRef corev1.ObjectReference `json:"objectRef,omitempty"`
+	// Refresh is one of normal and hard. Defaults to normal.
+	Refresh ArgoCDRefreshType `json:"refresh,omitempty"`
+	// Sync starts a sync operation after refresh.
+	Sync bool `json:"sync,omitempty"`
+	// Prune deleted resources during sync.
+	Prune bool `json:"prune,omitempty"`
+	// WaitForCompletion waits until refresh or sync finished and records the result in status.
+	WaitForCompletion bool `json:"waitForCompletion,omitempty"`
+	// TimeoutSeconds is the maximum time to wait. Defaults to 600.
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// ActionVersionedCopy creates an immutable copy of each ConfigMap and Secret source, named with a hash of its
+// content, and rewrites references of volumes, envFrom and env in pod template of the workload to the copy.
+// Unlike UpdatePodTemplate, a rollback of the workload also rolls back the config. Copies not referenced by any
+// ReplicaSet, ControllerRevision, Pod or workload in the namespace are deleted.
+type ActionVersionedCopy struct {
+	// ObjectRef specifies the workload, it must be in the same namespace as the sources.
+	ObjectRef corev1.ObjectReference `json:"objectRef,omitempty"`
+	// TemplatePath is the path of pod template in the workload, same as ActionUpdatePodTemplate.
+	TemplatePath string `json:"templatePath,omitempty"`
+}
+
+// ActionReplicate keeps copies of ConfigMap and Secret sources with the same name in all namespaces matching
+// NamespaceSelector. Copies are created in namespaces starting to match, updated when sources changed, and deleted
+// from namespaces no longer matching. Existing objects not created by the action are never overwritten.
+type ActionReplicate struct {
+	// Sources are names of sources to replicate, as "name" or "Kind/name". Defaults to all sources.
+	Sources []string `json:"sources,omitempty"`
+	// NamespaceSelector selects namespaces to replicate to, namespaces of sources are always skipped.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+	// Rollouts update pod template of consumers of copies in each selected namespace, namespace of ObjectRef is
+	// ignored and workloads missing in a namespace are skipped.
+	Rollouts []ActionUpdatePodTemplate `json:"rollouts,omitempty"`
+}
+
+// ActionRender renders templates over all sources and writes the results to a ConfigMap or Secret, which can
+// be used as a source by other rules. The target is only written when rendered data changed.
+type ActionRender struct {
+	// Target is the ConfigMap or Secret to write, it is created if not exist. Namespace defaults to namespace of
+	// the TriggerRule. Existing objects not created by the action are never overwritten.
+	Target corev1.ObjectReference `json:"target"`
+	// Data maps keys of the target to Go templates, data available in templates is the same as ActionPatch.
+	Data map[string]string `json:"data"`
+}
+
+// ActionRefreshVolumes sets an annotation on pods (not the pod template), which makes kubelet sync volumes of the
+// pods promptly instead of waiting for its sync period. Containers are not restarted, apps watching mounted files
+// see the new content sooner. Exactly one of ObjectRef and Selector should be set.
+type ActionRefreshVolumes struct {
+	// ObjectRef specifies a workload, pods matching spec.selector of the workload are annotated.
+	ObjectRef *corev1.ObjectReference `json:"objectRef,omitempty"`
+	// Selector selects pods in Namespace.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Namespace of pods selected by Selector. Defaults to namespace of the TriggerRule.
+	Namespace string `json:"namespace,omitempty"`
+	// Verify waits for VerifyDelaySeconds after pods are annotated, then checks they are still ready and their
+	// containers were not restarted.
+	Verify bool `json:"verify,omitempty"`
+	// VerifyDelaySeconds defaults to 10.
+	VerifyDelaySeconds *int64 `json:"verifyDelaySeconds,omitempty"`
+	// VerifyContent checks that pods see the new content of a source after they are annotated.
+	VerifyContent *ContentVerification `json:"verifyContent,omitempty"`
+}
+
+// ActionAuto inspects pod template of the workload. If any source is consumed by env, envFrom or a volume mounted
+// with subPath, which never change in running containers, the workload is restarted like UpdatePodTemplate.
+// If sources are only consumed by volumes, pods are reloaded in place like RefreshVolumes. The decision is
+// explained in status.
+type ActionAuto struct {
+	// ObjectRef specifies the workload.
+	ObjectRef corev1.ObjectReference `json:"objectRef,omitempty"`
+	// TemplatePath is the path of pod template in the workload, same as ActionUpdatePodTemplate.
+	TemplatePath string `json:"templatePath,omitempty"`
+	// VerifyContent is used when pods are reloaded in place, same as ActionRefreshVolumes.
+	VerifyContent *ContentVerification `json:"verifyContent,omitempty"`
+}
+
+// ContentVerification compares the digest of a source mounted in pods with the digest of the source. The digest is
+// the hex encoded sha256 of contents of keys concatenated in order of keys, the same as
+// "cat $(ls | sort) | sha256sum" in the mounted directory. Pods are checked by running "cat" in the container, or
+// by HTTPGet if set.
+type ContentVerification struct {
+	// Source is the name of the source, as "name" or "Kind/name".
+	Source string `json:"source"`
+	// Keys are keys of the source to compare. Defaults to all keys.
+	Keys []string `json:"keys,omitempty"`
+	// Container to exec in. Defaults to the first container.
+	Container string `json:"container,omitempty"`
+	// MountPath is the directory the source is mounted at, required unless HTTPGet is set.
+	MountPath string `json:"mountPath,omitempty"`
+	// HTTPGet reads the digest from an endpoint of the pod through the API server proxy instead of exec.
+	HTTPGet *DigestEndpoint `json:"httpGet,omitempty"`
+	// TimeoutSeconds is the maximum time to wait for pods to converge. Defaults to 120.
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+	// RestartStale triggers a rolling update of the workload in objectRef if pods are still stale after timeout.
+	RestartStale bool `json:"restartStale,omitempty"`
+}
+
+// DigestEndpoint is an HTTP endpoint of a pod responding with the digest of the loaded config.
+type DigestEndpoint struct {
+	Port int32  `json:"port"`
+	Path string `json:"path,omitempty"`
+}
+
+// ActionPhase is the result of an action.
+type ActionPhase string
+
+const (
+	ActionRunning   ActionPhase = "Running"
+	ActionSucceeded ActionPhase = "Succeeded"
+	ActionFailed    ActionPhase = "Failed"
+	// ActionExhausted means the action failed and will not be retried automatically.
+	ActionExhausted ActionPhase = "Exhausted"
+)
+
+// PodState is the state of config in a pod.
+type PodState string
+
+const (
+	PodConverged PodState = "Converged"
+	PodStale     PodState = "Stale"
+	PodUnknown   PodState = "Unknown"
+)
+
+// PodStatus is the result of verification of a pod.
+type PodStatus struct {
+	Name    string   `json:"name"`
+	State   PodState `json:"state"`
+	Message string   `json:"message,omitempty"`
+}
+
+// ActionStatus is the result of the last execution of an action.
+type ActionStatus struct {
+	// Index is the index of the action in spec.actions, or in spec.preActions and spec.postActions for hooks.
+	Index int         `json:"index"`
+	Phase ActionPhase `json:"phase,omitempty"`
+	// Reason is a brief CamelCase string describing the result.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// ObjectRef references the object updated or created by the action.
+	ObjectRef *corev1.ObjectReference `json:"objectRef,omitempty"`
+	// Objects are objects managed by the action, e.g. objects applied by Apply.
+	Objects []corev1.ObjectReference `json:"objects,omitempty"`
+	// Pods are results of verification of pods.
+	Pods []PodStatus `json:"pods,omitempty"`
+	// Targets are results of each workload selected by the action.
+	Targets []TargetStatus `json:"targets,omitempty"`
+	// Attempts is the number of consecutive failed attempts for the current sources.
+	Attempts int32 `json:"attempts,omitempty"`
+	// SourcesHash is the hash of versions of sources of the failed attempts.
+	SourcesHash    string      `json:"sourcesHash,omitempty"`
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// TargetStatus is the result of an action on one of the selected workloads.
+type TargetStatus struct {
+	ObjectRef corev1.ObjectReference `json:"objectRef"`
+	Phase     ActionPhase            `json:"phase,omitempty"`
+	Reason    string                 `json:"reason,omitempty"`
+	Message   string                 `json:"message,omitempty"`
 }
 
 // TriggerRuleStatus defines the observed state of TriggerRule
@@ -41,6 +427,73 @@ type TriggerRuleStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
+
+	// Actions are results of the last execution of actions.
+	Actions []ActionStatus `json:"actions,omitempty"`
+	// PreActions and PostActions are results of the last execution of hooks.
+	PreActions  []ActionStatus `json:"preActions,omitempty"`
+	PostActions []ActionStatus `json:"postActions,omitempty"`
+	// Discovery is the result of spec.discover.
+	Discovery *DiscoveryStatus `json:"discovery,omitempty"`
+	// Pending is set while changes of sources are waiting for the debounce period.
+	Pending *PendingStatus `json:"pending,omitempty"`
+	// Run is the run of the rule which is pending or in progress, it is resumed when kube-trigger restarts.
+	Run *RunStatus `json:"run,omitempty"`
+	// Queue lists rollouts of the rule waiting for the rollout budget of kube-trigger.
+	Queue []QueuedRollout `json:"queue,omitempty"`
+	// ObservedRetry is the value of annotation "trigger.app.example.com/retry" last handled, exhausted actions
+	// are retried when the annotation is set to a different value.
+	ObservedRetry string `json:"observedRetry,omitempty"`
+}
+
+// PendingStatus describes changes of sources waiting to be processed.
+type PendingStatus struct {
+	// FirstChangeTime is when the first change of the burst was observed.
+	FirstChangeTime metav1.Time `json:"firstChangeTime"`
+	// LastChangeTime is when the last change of the burst was observed.
+	LastChangeTime metav1.Time `json:"lastChangeTime"`
+	// ScheduledTime is when actions will be executed if no more changes are observed.
+	ScheduledTime metav1.Time `json:"scheduledTime"`
+}
+
+// RunState is the state of a run of a rule.
+type RunState string
+
+const (
+	// RunPending means the run is waiting, e.g. for the debounce period, a cooldown or a retry.
+	RunPending RunState = "Pending"
+	// RunRunning means actions of the run are being executed.
+	RunRunning RunState = "Running"
+)
+
+// RunStatus is a run of a rule which is not completed.
+type RunStatus struct {
+	// ID is the hash of versions of sources of the run. Actions keep it in their records, so a resumed run does
+	// not repeat actions already done.
+	ID    string   `json:"id"`
+	State RunState `json:"state"`
+	// Since is when the run entered the state.
+	Since metav1.Time `json:"since"`
+	// NotBefore is when a pending run will start.
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+}
+
+// QueuedRollout is a rollout waiting for the rollout budget.
+type QueuedRollout struct {
+	ObjectRef corev1.ObjectReference `json:"objectRef"`
+	// Position is the 1-based position in the queue of all rollouts waiting for the budget.
+	Position int32 `json:"position"`
+	// Since is when the rollout started waiting.
+	Since metav1.Time `json:"since"`
+}
+
+// DiscoveryStatus lists discovered workloads.
+type DiscoveryStatus struct {
+	// SourcesHash is the hash of versions of sources when workloads were processed. Workloads discovered without
+	// sources changed already use the latest sources, so no action is applied to them.
+	SourcesHash string `json:"sourcesHash,omitempty"`
+	// Targets are results of the discovered workloads, objectRef of each result is the workload.
+	Targets []ActionStatus `json:"targets,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,307 @@
+package reloader
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	"github.com/caitong93/kube-trigger/pkg/trigger"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_reloader")
+
+// Annotations of workloads compatible with Reloader (https://github.com/stakater/Reloader).
+const (
+	// ReloaderConfigMapAnnotation lists ConfigMaps, separated by comma, which restart the workload when changed.
+	ReloaderConfigMapAnnotation = "configmap.reloader.stakater.com/reload"
+	// ReloaderSecretAnnotation lists Secrets, separated by comma, which restart the workload when changed.
+	ReloaderSecretAnnotation = "secret.reloader.stakater.com/reload"
+	// ReloaderAutoAnnotation set to "true" restarts the workload when any ConfigMap or Secret it references changed.
+	ReloaderAutoAnnotation = "reloader.stakater.com/auto"
+	// ReloaderConfigMapAutoAnnotation is ReloaderAutoAnnotation limited to ConfigMaps.
+	ReloaderConfigMapAutoAnnotation = "configmap.reloader.stakater.com/auto"
+	// ReloaderSecretAutoAnnotation is ReloaderAutoAnnotation limited to Secrets.
+	ReloaderSecretAutoAnnotation = "secret.reloader.stakater.com/auto"
+)
+
+// Annotations of workloads equivalent to those of Reloader.
+const (
+	ConfigMapsAnnotation = "trigger.app.example.com/configmaps"
+	SecretsAnnotation    = "trigger.app.example.com/secrets"
+	AutoAnnotation       = "trigger.app.example.com/auto"
+)
+
+// RuleName is the name of implicit rules built from annotations, the record of them is kept in pod template of
+// workloads with key trigger.GetRecordKey(RuleName, namespace). It is not a valid name of objects, so it never
+// conflicts with TriggerRules.
+const RuleName = "_reloader"
+
+// workloadKind describes a kind of workloads supporting the annotations.
+type workloadKind struct {
+	kind    string
+	newObj  func() runtime.Object
+	newList func() runtime.Object
+}
+
+var workloadKinds = []workloadKind{
+	{
+		kind:    "Deployment",
+		newObj:  func() runtime.Object { return &appsv1.Deployment{} },
+		newList: func() runtime.Object { return &appsv1.DeploymentList{} },
+	},
+	{
+		kind:    "StatefulSet",
+		newObj:  func() runtime.Object { return &appsv1.StatefulSet{} },
+		newList: func() runtime.Object { return &appsv1.StatefulSetList{} },
+	},
+	{
+		kind:    "DaemonSet",
+		newObj:  func() runtime.Object { return &appsv1.DaemonSet{} },
+		newList: func() runtime.Object { return &appsv1.DaemonSetList{} },
+	},
+}
+
+// workload is the metadata and pod template of a workload.
+type workload struct {
+	meta     *metav1.ObjectMeta
+	template *corev1.PodTemplateSpec
+}
+
+// workloadsOf returns workloads of obj, which is a workload or a list of workloads.
+func workloadsOf(obj runtime.Object) []workload {
+	var ret []workload
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		ret = append(ret, workload{&o.ObjectMeta, &o.Spec.Template})
+	case *appsv1.StatefulSet:
+		ret = append(ret, workload{&o.ObjectMeta, &o.Spec.Template})
+	case *appsv1.DaemonSet:
+		ret = append(ret, workload{&o.ObjectMeta, &o.Spec.Template})
+	case *appsv1.DeploymentList:
+		for i := range o.Items {
+			ret = append(ret, workloadsOf(&o.Items[i])...)
+		}
+	case *appsv1.StatefulSetList:
+		for i := range o.Items {
+			ret = append(ret, workloadsOf(&o.Items[i])...)
+		}
+	case *appsv1.DaemonSetList:
+		for i := range o.Items {
+			ret = append(ret, workloadsOf(&o.Items[i])...)
+		}
+	}
+	return ret
+}
+
+// Add creates a Controller for each kind of workloads and adds them to the Manager. The Manager will set fields on
+// the Controllers and Start them when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	for _, wk := range workloadKinds {
+		if err := add(mgr, wk, &ReconcileReloader{client: mgr.GetClient(), kind: wk}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds a new Controller for workloads of the kind to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, wk workloadKind, r reconcile.Reconciler) error {
+	c, err := controller.New("reloader-"+strings.ToLower(wk.kind)+"-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Only updates of sources trigger workloads, as Reloader does. Objects listed when the cache starts are
+	// delivered as creations, reacting to them would restart every annotated workload.
+	for _, src := range []struct {
+		kind string
+		obj  runtime.Object
+	}{{"ConfigMap", &corev1.ConfigMap{}}, {"Secret", &corev1.Secret{}}} {
+		err = c.Watch(&source.Kind{Type: src.obj}, &handler.EnqueueRequestsFromMapFunc{ToRequests: enqueWorkloadForConfig(mgr.GetClient(), wk, src.kind)}, updateOnly)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueWorkloadForConfig requeues workloads of the kind in namespace of the object, whose annotations select it.
+// Kind of the object is passed as a parameter, see enqueTriggerRuleForConfig.
+func enqueWorkloadForConfig(c client.Client, wk workloadKind, kind string) handler.ToRequestsFunc {
+	return func(o handler.MapObject) []reconcile.Request {
+		list := wk.newList()
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		if err := c.List(ctx, &client.ListOptions{Namespace: o.Meta.GetNamespace()}, list); err != nil {
+			log.Error(err, "err list workloads", "kind", wk.kind)
+			return nil
+		}
+
+		var reqs []reconcile.Request
+		for _, w := range workloadsOf(list) {
+			for _, ref := range annotatedSources(w) {
+				if ref.Kind == kind && ref.Name == o.Meta.GetName() {
+					reqs = append(reqs, reconcile.Request{
+						NamespacedName: types.NamespacedName{Namespace: w.meta.Namespace, Name: w.meta.Name},
+					})
+					break
+				}
+			}
+		}
+		return reqs
+	}
+}
+
+// updateOnly filters out events other than updates.
+var updateOnly = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.MetaOld == nil || e.MetaNew == nil {
+			return true
+		}
+		return e.MetaOld.GetResourceVersion() != e.MetaNew.GetResourceVersion()
+	},
+}
+
+// annotatedSources returns ConfigMaps and Secrets selected by annotations of the workload, sorted by kind then
+// name. Namespace of the references is not set.
+func annotatedSources(w workload) []corev1.ObjectReference {
+	annotations := w.meta.Annotations
+	selected := map[corev1.ObjectReference]bool{}
+	for kind, keys := range map[string][]string{
+		"ConfigMap": {ReloaderConfigMapAnnotation, ConfigMapsAnnotation},
+		"Secret":    {ReloaderSecretAnnotation, SecretsAnnotation},
+	} {
+		for _, key := range keys {
+			for _, name := range strings.Split(annotations[key], ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					selected[corev1.ObjectReference{Kind: kind, Name: name}] = true
+				}
+			}
+		}
+	}
+
+	auto := map[string]bool{}
+	if isTrue(annotations[ReloaderAutoAnnotation]) || isTrue(annotations[AutoAnnotation]) {
+		auto["ConfigMap"], auto["Secret"] = true, true
+	}
+	if isTrue(annotations[ReloaderConfigMapAutoAnnotation]) {
+		auto["ConfigMap"] = true
+	}
+	if isTrue(annotations[ReloaderSecretAutoAnnotation]) {
+		auto["Secret"] = true
+	}
+	if len(auto) > 0 {
+		refs, err := trigger.PodSpecSources(&w.template.Spec)
+		if err != nil {
+			log.Error(err, "err find sources", "namespace", w.meta.Namespace, "name", w.meta.Name)
+		}
+		for _, ref := range refs {
+			if auto[ref.Kind] {
+				selected[ref] = true
+			}
+		}
+	}
+
+	var refs []corev1.ObjectReference
+	for ref := range selected {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Kind != refs[j].Kind {
+			return refs[i].Kind < refs[j].Kind
+		}
+		return refs[i].Name < refs[j].Name
+	})
+	return refs
+}
+
+func isTrue(v string) bool {
+	return strings.EqualFold(strings.TrimSpace(v), "true")
+}
+
+// blank assignment to verify that ReconcileReloader implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileReloader{}
+
+// ReconcileReloader reconciles workloads of a kind annotated with sources.
+type ReconcileReloader struct {
+	client client.Client
+	kind   workloadKind
+}
+
+// Reconcile builds an implicit rule for the workload, which updates its pod template when sources selected by
+// annotations changed, and adds it to the trigger.
+func (r *ReconcileReloader) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name, "Kind", r.kind.kind)
+	reqLogger.Info("Reconciling annotated workload")
+
+	obj := r.kind.newObj()
+	if err := r.client.Get(context.TODO(), request.NamespacedName, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	w := workloadsOf(obj)[0]
+
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: RuleName, Namespace: request.Namespace},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Actions: []appv1alpha1.Action{{
+				UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{
+					ObjectRef: corev1.ObjectReference{
+						APIVersion: "apps/v1",
+						Kind:       r.kind.kind,
+						Namespace:  request.Namespace,
+						Name:       request.Name,
+					},
+				},
+			}},
+		},
+	}
+	for _, ref := range annotatedSources(w) {
+		// Sources which do not exist, e.g. optional ones, are left out, otherwise the rule would always fail.
+		var src runtime.Object = &corev1.ConfigMap{}
+		if ref.Kind == "Secret" {
+			src = &corev1.Secret{}
+		}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: request.Namespace, Name: ref.Name}, src)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		ref.Namespace = request.Namespace
+		rule.Spec.Sources = append(rule.Spec.Sources, appv1alpha1.Source{ObjectRef: ref})
+	}
+	if len(rule.Spec.Sources) == 0 {
+		return reconcile.Result{}, nil
+	}
+
+	// Keys of TriggerRules never contain "/", so implicit rules do not replace them in the queue.
+	trigger.Add(types.NamespacedName{
+		Namespace: request.Namespace,
+		Name:      RuleName + "/" + r.kind.kind + "/" + request.Name,
+	}, rule)
+	return reconcile.Result{}, nil
+}
@@ -6,14 +6,17 @@ import (
 
 	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
 	"github.com/caitong93/kube-trigger/pkg/trigger"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"sigs.k8s.io/controller-runtime/pkg/source"
@@ -69,6 +72,69 @@ func enqueTriggerRuleForConfig(c client.Client, kind string) handler.ToRequestsF
 	}
 }
 
+// enqueTriggerRuleForNamespace requeues TriggerRules which replicate sources, so copies are created in new
+// namespaces and deleted from namespaces no longer matching.
+func enqueTriggerRuleForNamespace(c client.Client) handler.ToRequestsFunc {
+	return func(o handler.MapObject) []reconcile.Request {
+		rules := &appv1alpha1.TriggerRuleList{}
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		if err := c.List(ctx, &client.ListOptions{}, rules); err != nil {
+			log.Error(err, "err list rules")
+			return nil
+		}
+
+		var reqs []reconcile.Request
+		for _, item := range rules.Items {
+			for _, action := range item.Spec.Actions {
+				if action.Replicate != nil {
+					reqs = append(reqs, reconcile.Request{
+						NamespacedName: types.NamespacedName{
+							Namespace: item.Namespace,
+							Name:      item.Name,
+						},
+					})
+					break
+				}
+			}
+		}
+		return reqs
+	}
+}
+
+// enqueTriggerRuleForWorkload requeues TriggerRules discovering workloads with sources in namespace of the
+// workload, so discovered workloads are kept up to date.
+func enqueTriggerRuleForWorkload(c client.Client) handler.ToRequestsFunc {
+	return func(o handler.MapObject) []reconcile.Request {
+		rules := &appv1alpha1.TriggerRuleList{}
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		if err := c.List(ctx, &client.ListOptions{}, rules); err != nil {
+			log.Error(err, "err list rules")
+			return nil
+		}
+
+		var reqs []reconcile.Request
+		for _, item := range rules.Items {
+			if item.Spec.Discover == nil {
+				continue
+			}
+			for _, src := range item.Spec.Sources {
+				if src.ObjectRef.Namespace == o.Meta.GetNamespace() {
+					reqs = append(reqs, reconcile.Request{
+						NamespacedName: types.NamespacedName{
+							Namespace: item.Namespace,
+							Name:      item.Name,
+						},
+					})
+					break
+				}
+			}
+		}
+		return reqs
+	}
+}
+
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
@@ -77,8 +143,8 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	// Watch for changes to primary resource TriggerRule
-	err = c.Watch(&source.Kind{Type: &appv1alpha1.TriggerRule{}}, &handler.EnqueueRequestForObject{})
+	// Watch for changes to primary resource TriggerRule, status updates made by trigger are ignored
+	err = c.Watch(&source.Kind{Type: &appv1alpha1.TriggerRule{}}, &handler.EnqueueRequestForObject{}, generationOrRetryChanged)
 	if err != nil {
 		return err
 	}
@@ -93,9 +159,42 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to workloads and requeue TriggerRules discovering them, status updates are ignored
+	for _, obj := range []runtime.Object{&appsv1.Deployment{}, &appsv1.StatefulSet{}, &appsv1.DaemonSet{}} {
+		if err = c.Watch(&source.Kind{Type: obj}, &handler.EnqueueRequestsFromMapFunc{ToRequests: enqueTriggerRuleForWorkload(mgr.GetClient())}, generationChanged); err != nil {
+			return err
+		}
+	}
+
+	// Watch for changes to Namespaces and requeue TriggerRules replicating sources
+	if err = c.Watch(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: enqueTriggerRuleForNamespace(mgr.GetClient())}); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// generationChanged filters out updates which do not change spec, e.g. status updates.
+var generationChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.MetaOld == nil || e.MetaNew == nil {
+			return true
+		}
+		return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration()
+	},
+}
+
+// generationOrRetryChanged is generationChanged, plus updates requesting to retry exhausted actions.
+var generationOrRetryChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.MetaOld == nil || e.MetaNew == nil {
+			return true
+		}
+		return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration() ||
+			e.MetaOld.GetAnnotations()[trigger.RetryAnnotation] != e.MetaNew.GetAnnotations()[trigger.RetryAnnotation]
+	},
+}
+
 // blank assignment to verify that ReconcileTriggerRule implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileTriggerRule{}
 
@@ -0,0 +1,105 @@
+package trigger
+
+import (
+	"fmt"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// AllNamespaces in Options.TrustedNamespaces trusts rules in all namespaces.
+const AllNamespaces = "*"
+
+// trusted returns true if rule may access objects in other namespaces and cluster-scoped objects.
+func (t *DefaultTrigger) trusted(rule *appv1alpha1.TriggerRule) bool {
+	return t.trustedNamespaces[AllNamespaces] || t.trustedNamespaces[rule.Namespace]
+}
+
+// checkNamespace returns an error if rule may not access objects in namespace. Rules only access their own
+// namespace, unless their namespace is trusted.
+func (t *DefaultTrigger) checkNamespace(rule *appv1alpha1.TriggerRule, namespace string) error {
+	if namespace == rule.Namespace || t.trusted(rule) {
+		return nil
+	}
+	return fmt.Errorf("namespace %q is not accessible by rules in namespace %v, which is not trusted", namespace, rule.Namespace)
+}
+
+// checkTrusted returns an error unless rule is trusted, what names the feature accessing other namespaces.
+func (t *DefaultTrigger) checkTrusted(rule *appv1alpha1.TriggerRule, what string) error {
+	if t.trusted(rule) {
+		return nil
+	}
+	return fmt.Errorf("%v accesses other namespaces, rules in namespace %v are not trusted", what, rule.Namespace)
+}
+
+// checkRef returns an error if rule may not access the object of ref, cluster-scoped objects are only accessed by
+// trusted rules.
+func (t *DefaultTrigger) checkRef(rule *appv1alpha1.TriggerRule, ref *corev1.ObjectReference) error {
+	if t.trusted(rule) {
+		return nil
+	}
+	mapping, err := t.mappingFor(ref)
+	if err != nil {
+		return err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return fmt.Errorf("%v %v is cluster-scoped, rules in namespace %v are not trusted", ref.Kind, ref.Name, rule.Namespace)
+	}
+	return t.checkNamespace(rule, ref.Namespace)
+}
+
+// checkAction returns an error if objects referenced by the action are not accessible by rule. Objects known only
+// once the action runs, e.g. manifests to apply, are checked by the action.
+func (t *DefaultTrigger) checkAction(rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) error {
+	defaultNamespace := func(ns string) string {
+		if ns == "" {
+			return rule.Namespace
+		}
+		return ns
+	}
+	switch {
+	case action.UpdatePodTemplate != nil && action.UpdatePodTemplate.Selector != nil:
+		if action.UpdatePodTemplate.Selector.NamespaceSelector != nil {
+			return t.checkTrusted(rule, "namespaceSelector")
+		}
+	case action.UpdatePodTemplate != nil:
+		return t.checkRef(rule, &action.UpdatePodTemplate.ObjectRef)
+	case action.RunJob != nil:
+		if ref := action.RunJob.JobRef; ref != nil {
+			return t.checkNamespace(rule, ref.Namespace)
+		}
+		if ref := action.RunJob.CronJobRef; ref != nil {
+			return t.checkNamespace(rule, ref.Namespace)
+		}
+	case action.Scale != nil:
+		return t.checkRef(rule, &action.Scale.ObjectRef)
+	case action.Patch != nil:
+		return t.checkRef(rule, &action.Patch.ObjectRef)
+	case action.Apply != nil:
+		if ref := action.Apply.ManifestsFrom; ref != nil {
+			if err := t.checkNamespace(rule, defaultNamespace(ref.Namespace)); err != nil {
+				return err
+			}
+		}
+		return t.checkNamespace(rule, defaultNamespace(action.Apply.Namespace))
+	case action.Flux != nil:
+		return t.checkNamespace(rule, action.Flux.ObjectRef.Namespace)
+	case action.ArgoCD != nil:
+		return t.checkNamespace(rule, action.ArgoCD.ObjectRef.Namespace)
+	case action.VersionedCopy != nil:
+		return t.checkRef(rule, &action.VersionedCopy.ObjectRef)
+	case action.Replicate != nil:
+		return t.checkTrusted(rule, "replicate")
+	case action.Render != nil:
+		return t.checkNamespace(rule, defaultNamespace(action.Render.Target.Namespace))
+	case action.RefreshVolumes != nil:
+		if ref := action.RefreshVolumes.ObjectRef; ref != nil {
+			return t.checkRef(rule, ref)
+		}
+		return t.checkNamespace(rule, defaultNamespace(action.RefreshVolumes.Namespace))
+	case action.Auto != nil:
+		return t.checkRef(rule, &action.Auto.ObjectRef)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package trigger
+
+import (
+	"testing"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCheckAction(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion, rbacv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	mapper.Add(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), meta.RESTScopeRoot)
+	rule := &appv1alpha1.TriggerRule{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"}}
+	patch := func(ref corev1.ObjectReference) *appv1alpha1.Action {
+		return &appv1alpha1.Action{Patch: &appv1alpha1.ActionPatch{ObjectRef: ref}}
+	}
+
+	cases := []struct {
+		action  *appv1alpha1.Action
+		trusted bool
+		allowed bool
+	}{
+		{patch(corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "foo-ns"}), false, true},
+		{patch(corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "bar-ns"}), false, false},
+		{patch(corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "bar-ns"}), true, true},
+		{patch(corev1.ObjectReference{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "admin"}), false, false},
+		{patch(corev1.ObjectReference{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "admin"}), true, true},
+		{&appv1alpha1.Action{Render: &appv1alpha1.ActionRender{Target: corev1.ObjectReference{Kind: "Secret", Name: "foo"}}}, false, true},
+		{&appv1alpha1.Action{Replicate: &appv1alpha1.ActionReplicate{}}, false, false},
+		{&appv1alpha1.Action{Replicate: &appv1alpha1.ActionReplicate{}}, true, true},
+	}
+	for i, c := range cases {
+		opts := Options{}
+		if c.trusted {
+			opts.TrustedNamespaces = []string{"foo-ns"}
+		}
+		tr := New(nil, nil, nil, mapper, nil, opts).(*DefaultTrigger)
+		if err := tr.checkAction(rule, c.action); (err == nil) != c.allowed {
+			t.Errorf("case %d: expect allowed %v, got %v", i, c.allowed, err)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// FieldManager is the field manager used by server-side apply.
+	FieldManager = "kube-trigger"
+	// ApplyNameLabel is added to objects applied by an apply action with a name, value is the name.
+	ApplyNameLabel = "trigger.app.example.com/apply-name"
+
+	applyPatchType types.PatchType = "application/apply-patch+yaml"
+)
+
+// apply renders manifests and applies them with server-side apply. Applied objects are labeled with the rule and
+// the action, and listed in status so they can be pruned later.
+func (t *DefaultTrigger) apply(ctx context.Context, rule *appv1alpha1.TriggerRule, index int, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.Apply
+	annotationKey := GetRecordKey(rule.Name, rule.Namespace)
+	if spec.Prune && spec.Name == "" {
+		return nil, fmt.Errorf("name is required by prune")
+	}
+	selector := applySelector(rule, index, spec)
+
+	// Status of rule may be stale, objects are read from the latest status.
+	latest, err := t.latestStatus(rule)
+	if err != nil {
+		return nil, err
+	}
+	var previous, recorded []corev1.ObjectReference
+	for _, as := range latest.Actions {
+		recorded = append(recorded, as.Objects...)
+		if as.Index == index {
+			previous = as.Objects
+		}
+	}
+	annotations, err := t.lastAppliedAnnotations(previous, selector)
+	if err != nil {
+		return nil, err
+	}
+	// Edits of the manifests are applied without changes of sources.
+	templates, err := t.manifestTemplates(rule, index, spec)
+	if err != nil {
+		return nil, err
+	}
+	manifestsSpec, err := specHash(templates)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := t.generateSpecRecord(rule, annotations, annotationKey, manifestsSpec)
+	if err != nil {
+		return nil, fmt.Errorf("err generate record: %w", err)
+	}
+	if rec == nil {
+		return nil, nil
+	}
+
+	objs, err := t.renderManifests(rule, templates, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("err encode %#v: %w", rec, err)
+	}
+	namespace := spec.Namespace
+	if namespace == "" {
+		namespace = rule.Namespace
+	}
+
+	var applied []corev1.ObjectReference
+	for _, obj := range objs {
+		lbs := obj.GetLabels()
+		if lbs == nil {
+			lbs = map[string]string{}
+		}
+		for k, v := range selector {
+			lbs[k] = v
+		}
+		obj.SetLabels(lbs)
+		ann := obj.GetAnnotations()
+		if ann == nil {
+			ann = map[string]string{}
+		}
+		ann[annotationKey] = string(val)
+		obj.SetAnnotations(ann)
+
+		ref, err := t.applyObject(rule, obj, namespace)
+		if err != nil {
+			return &appv1alpha1.ActionStatus{
+				Phase:   appv1alpha1.ActionFailed,
+				Reason:  "ApplyFailed",
+				Message: err.Error(),
+				Objects: append(applied, previous...),
+			}, err
+		}
+		applied = append(applied, *ref)
+	}
+
+	status := &appv1alpha1.ActionStatus{
+		Phase:   appv1alpha1.ActionSucceeded,
+		Reason:  "Applied",
+		Message: fmt.Sprintf("%d objects applied", len(applied)),
+		Objects: applied,
+	}
+	if spec.Prune {
+		pruned, err := t.prune(selector, recorded, applied)
+		if err != nil {
+			status.Phase = appv1alpha1.ActionFailed
+			status.Reason = "PruneFailed"
+			status.Message = err.Error()
+			return status, err
+		}
+		status.Message += fmt.Sprintf(", %d objects pruned", pruned)
+	}
+	return status, nil
+}
+
+// applySelector returns labels of objects applied by the action. Objects of a named action are identified by the
+// name instead of the index, so the action owns the same objects when actions are reordered.
+func applySelector(rule *appv1alpha1.TriggerRule, index int, spec *appv1alpha1.ActionApply) map[string]string {
+	if spec.Name == "" {
+		return actionSelector(rule, index)
+	}
+	return map[string]string{
+		RuleUIDLabel:   string(rule.UID),
+		ApplyNameLabel: spec.Name,
+	}
+}
+
+// lastAppliedAnnotations returns annotations of the first object applied last time, nil if the object is not applied
+// by the action matching selector, e.g. actions have been reordered.
+func (t *DefaultTrigger) lastAppliedAnnotations(previous []corev1.ObjectReference, selector map[string]string) (map[string]string, error) {
+	if len(previous) == 0 {
+		return nil, nil
+	}
+	ref := previous[0]
+	ri, _, err := t.resourceFor(&ref)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("err get %v %s/%s: %w", ref.Kind, ref.Namespace, ref.Name, err)
+	}
+	if !labels.SelectorFromSet(selector).Matches(labels.Set(obj.GetLabels())) {
+		return nil, nil
+	}
+	return obj.GetAnnotations(), nil
+}
+
+// manifestTemplates returns templates of manifests of the action, keyed by their names.
+func (t *DefaultTrigger) manifestTemplates(rule *appv1alpha1.TriggerRule, index int, spec *appv1alpha1.ActionApply) (map[string]string, error) {
+	templates := map[string]string{}
+	switch {
+	case spec.Manifests != "":
+		templates[fmt.Sprintf("actions[%d].manifests", index)] = spec.Manifests
+	case spec.ManifestsFrom != nil:
+		ref := spec.ManifestsFrom
+		ns := ref.Namespace
+		if ns == "" {
+			ns = rule.Namespace
+		}
+		cm, err := t.client.CoreV1().ConfigMaps(ns).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("err get configmap: %w", err)
+		}
+		for k, v := range cm.Data {
+			templates[k] = v
+		}
+	default:
+		return nil, fmt.Errorf("one of manifests and manifestsFrom must be set")
+	}
+	return templates, nil
+}
+
+// renderManifests renders templates of manifests and decodes objects from them.
+func (t *DefaultTrigger) renderManifests(rule *appv1alpha1.TriggerRule, templates map[string]string, rec *Record) ([]*unstructured.Unstructured, error) {
+	data, err := t.templateData(rule, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objs []*unstructured.Unstructured
+	for _, name := range names {
+		rendered, err := renderTemplate(name, templates[name], data)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decodeManifests(rendered)
+		if err != nil {
+			return nil, fmt.Errorf("err decode %v: %w", name, err)
+		}
+		objs = append(objs, decoded...)
+	}
+	return objs, nil
+}
+
+// decodeManifests decodes objects from YAML documents, empty documents are ignored.
+func decodeManifests(data []byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		content := map[string]interface{}{}
+		if err := decoder.Decode(&content); err != nil {
+			if err == io.EOF {
+				return objs, nil
+			}
+			return nil, err
+		}
+		if len(content) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: content}
+		if obj.GetAPIVersion() == "" || obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, fmt.Errorf("apiVersion, kind and metadata.name are required")
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// applyObject applies obj with server-side apply.
+func (t *DefaultTrigger) applyObject(rule *appv1alpha1.TriggerRule, obj *unstructured.Unstructured, namespace string) (*corev1.ObjectReference, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := t.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("err find resource of %v: %w", gvk, err)
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		}
+		if err := t.checkNamespace(rule, obj.GetNamespace()); err != nil {
+			return nil, fmt.Errorf("%v %v: %w", gvk.Kind, obj.GetName(), err)
+		}
+	} else {
+		obj.SetNamespace("")
+		if !t.trusted(rule) {
+			return nil, fmt.Errorf("%v %v is cluster-scoped, rules in namespace %v are not trusted", gvk.Kind, obj.GetName(), rule.Namespace)
+		}
+	}
+
+	body, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, fmt.Errorf("err encode %v: %w", obj.GetName(), err)
+	}
+	t.logger.Info("Apply object", "kind", gvk.Kind, "namespace", obj.GetNamespace(), "name", obj.GetName())
+	err = t.client.Discovery().RESTClient().Patch(applyPatchType).
+		AbsPath(resourcePath(mapping.Resource, obj.GetNamespace(), obj.GetName())).
+		Param("fieldManager", FieldManager).
+		Param("force", strconv.FormatBool(true)).
+		Body(body).
+		Do().
+		Error()
+	if err != nil {
+		return nil, fmt.Errorf("err apply %v %s/%s: %w", gvk.Kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+	return &corev1.ObjectReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}, nil
+}
+
+// resourcePath returns the REST path of an object.
+func resourcePath(gvr schema.GroupVersionResource, namespace, name string) string {
+	p := path.Join("/apis", gvr.Group, gvr.Version)
+	if gvr.Group == "" {
+		p = path.Join("/api", gvr.Version)
+	}
+	if namespace != "" {
+		p = path.Join(p, "namespaces", namespace)
+	}
+	return path.Join(p, gvr.Resource, name)
+}
+
+// prune deletes objects labeled by selector which are not applied this time. Kinds and namespaces of objects
+// recorded in status of the rule and applied this time are searched, so no list across all namespaces is needed.
+func (t *DefaultTrigger) prune(selector map[string]string, recorded, applied []corev1.ObjectReference) (int, error) {
+	keep := map[corev1.ObjectReference]bool{}
+	scopes := map[corev1.ObjectReference]bool{}
+	for _, ref := range applied {
+		keep[ref] = true
+		scopes[corev1.ObjectReference{APIVersion: ref.APIVersion, Kind: ref.Kind, Namespace: ref.Namespace}] = true
+	}
+	for _, ref := range recorded {
+		scopes[corev1.ObjectReference{APIVersion: ref.APIVersion, Kind: ref.Kind, Namespace: ref.Namespace}] = true
+	}
+
+	pruned := 0
+	for scope := range scopes {
+		scope := scope
+		ri, _, err := t.resourceFor(&scope)
+		if err != nil {
+			return pruned, err
+		}
+		list, err := ri.List(metav1.ListOptions{LabelSelector: labels.SelectorFromSet(selector).String()})
+		if err != nil {
+			return pruned, fmt.Errorf("err list %v in %q: %w", scope.Kind, scope.Namespace, err)
+		}
+		for _, item := range list.Items {
+			ref := corev1.ObjectReference{
+				APIVersion: scope.APIVersion,
+				Kind:       scope.Kind,
+				Namespace:  item.GetNamespace(),
+				Name:       item.GetName(),
+			}
+			if keep[ref] {
+				continue
+			}
+			t.logger.Info("Prune object", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name)
+			ri, _, err := t.resourceFor(&ref)
+			if err != nil {
+				return pruned, err
+			}
+			propagation := metav1.DeletePropagationBackground
+			if err := ri.Delete(ref.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !errors.IsNotFound(err) {
+				return pruned, fmt.Errorf("err delete %v %s/%s: %w", ref.Kind, ref.Namespace, ref.Name, err)
+			}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,97 @@
+package trigger
+
+import (
+	"encoding/json"
+	"testing"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestDecodeManifests(t *testing.T) {
+	data := []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  a: b
+---
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: bar
+  namespace: bar-ns
+`)
+	objs, err := decodeManifests(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("Expect 2 objects, got %d", len(objs))
+	}
+	if objs[1].GetKind() != "Deployment" || objs[1].GetNamespace() != "bar-ns" {
+		t.Errorf("Unexpected object %#v", objs[1])
+	}
+
+	if _, err := decodeManifests([]byte("kind: ConfigMap\n")); err == nil {
+		t.Error("Expect error for object without apiVersion and name")
+	}
+}
+
+func TestResourcePath(t *testing.T) {
+	cases := []struct {
+		gvr       schema.GroupVersionResource
+		namespace string
+		expect    string
+	}{
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, namespace: "foo", expect: "/api/v1/namespaces/foo/configmaps/bar"},
+		{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, namespace: "foo", expect: "/apis/apps/v1/namespaces/foo/deployments/bar"},
+		{gvr: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, expect: "/api/v1/namespaces/bar"},
+	}
+	for _, c := range cases {
+		if got := resourcePath(c.gvr, c.namespace, "bar"); got != c.expect {
+			t.Errorf("Expect %v, got %v", c.expect, got)
+		}
+	}
+}
+
+func TestApplySelector(t *testing.T) {
+	rule := &appv1alpha1.TriggerRule{ObjectMeta: metav1.ObjectMeta{UID: "uid"}}
+	named := &appv1alpha1.ActionApply{Name: "monitoring"}
+	a, b := applySelector(rule, 0, named), applySelector(rule, 3, named)
+	if a[ApplyNameLabel] != "monitoring" || a[ActionIndexLabel] != "" || b[ApplyNameLabel] != a[ApplyNameLabel] {
+		t.Errorf("expect named action selected by name regardless of index, got %v and %v", a, b)
+	}
+	if s := applySelector(rule, 2, &appv1alpha1.ActionApply{}); s[ActionIndexLabel] != "2" {
+		t.Errorf("expect unnamed action selected by index, got %v", s)
+	}
+}
+
+func TestGenerateSpecRecord(t *testing.T) {
+	tr := New(nil, nil, nil, nil, nil, Options{}).(*DefaultTrigger)
+	key := GetRecordKey("foo", "foo-ns")
+	rule := &appv1alpha1.TriggerRule{Spec: appv1alpha1.TriggerRuleSpec{
+		Sources: []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}}},
+	}}
+	rec, err := tr.generateSpecRecord(rule, nil, key, "a")
+	if err != nil || rec == nil || rec.Spec != "a" {
+		t.Fatalf("expect new record with spec, got %+v, %v", rec, err)
+	}
+	val, _ := json.Marshal(rec)
+	annotations := map[string]string{key: string(val)}
+
+	if rec, err := tr.generateSpecRecord(rule, annotations, key, "a"); rec != nil || err != nil {
+		t.Errorf("expect no record if neither sources nor spec changed, got %+v, %v", rec, err)
+	}
+	if rec, err := tr.generateSpecRecord(rule, annotations, key, "b"); err != nil || rec == nil || rec.Spec != "b" {
+		t.Errorf("expect new record if spec changed, got %+v, %v", rec, err)
+	}
+	rule.Spec.Sources[0].ObjectRef.ResourceVersion = "2"
+	if rec, err := tr.generateSpecRecord(rule, annotations, key, "a"); err != nil || rec == nil || rec.Spec != "a" {
+		t.Errorf("expect new record if sources changed, got %+v, %v", rec, err)
+	}
+}
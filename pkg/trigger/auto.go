@@ -0,0 +1,75 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// auto restarts the workload or reloads its pods in place, depending on how sources are consumed by the pod
+// template. The decision is prepended to the message of status.
+func (t *DefaultTrigger) auto(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.Auto
+	ref := &spec.ObjectRef
+
+	ri, mapping, err := t.resourceFor(ref)
+	if err != nil {
+		return nil, err
+	}
+	templatePath, err := podTemplatePath(mapping.GroupVersionKind.GroupKind(), spec.TemplatePath)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("err get %v: %w", mapping.GroupVersionKind.Kind, err)
+	}
+	podSpec, found, err := unstructured.NestedMap(obj.Object, append(templatePath, "spec")...)
+	if err != nil || !found {
+		return nil, fmt.Errorf("pod template not found at %v in %v %s/%s", strings.Join(templatePath, "."), ref.Kind, ref.Namespace, ref.Name)
+	}
+
+	sources := map[string]map[string]bool{}
+	for _, src := range rule.Spec.Sources {
+		if src.ObjectRef.Namespace != obj.GetNamespace() {
+			continue
+		}
+		if sources[src.ObjectRef.Kind] == nil {
+			sources[src.ObjectRef.Kind] = map[string]bool{}
+		}
+		sources[src.ObjectRef.Kind][src.ObjectRef.Name] = true
+	}
+	mode, explanation := decideReload(podSpec, sources)
+
+	var status *appv1alpha1.ActionStatus
+	switch mode {
+	case reloadRestart:
+		status, err = t.updatePodTemplate(ctx, rule, &appv1alpha1.Action{
+			UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{ObjectRef: *ref, TemplatePath: spec.TemplatePath},
+		})
+	case reloadInPlace:
+		status, err = t.refreshVolumes(ctx, rule, &appv1alpha1.Action{
+			RefreshVolumes: &appv1alpha1.ActionRefreshVolumes{ObjectRef: ref, VerifyContent: spec.VerifyContent},
+		})
+	default:
+		return &appv1alpha1.ActionStatus{
+			Phase:     appv1alpha1.ActionSucceeded,
+			Reason:    "NotConsumed",
+			Message:   explanation,
+			ObjectRef: ref.DeepCopy(),
+		}, nil
+	}
+	if status == nil {
+		return nil, err
+	}
+	t.logger.Info("Auto action", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name, "decision", explanation)
+	if status.Message != "" {
+		explanation += ". " + status.Message
+	}
+	status.Message = explanation
+	return status, err
+}
@@ -0,0 +1,232 @@
+package trigger
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// queueStatusInterval is how often positions of rollouts waiting for the budget are written to status.
+const queueStatusInterval = 5 * time.Second
+
+// rolloutBudget limits the number of rollouts in progress, globally, per namespace and per node pool. Rollouts
+// exceeding the budget wait in order of priority then arrival, a rollout is admitted once it fits even if rollouts
+// before it are still waiting for another limit.
+type rolloutBudget struct {
+	global      int
+	perNS       int
+	perNodePool int
+	// nodePoolLabel is the label of nodes naming their node pool.
+	nodePoolLabel string
+	jitter        time.Duration
+
+	mu         sync.Mutex
+	running    int
+	namespaces map[string]int
+	pools      map[string]int
+	waiting    []*targetBatch
+}
+
+func newRolloutBudget(opts Options) *rolloutBudget {
+	return &rolloutBudget{
+		global:        opts.MaxRollouts,
+		perNS:         opts.MaxRolloutsPerNamespace,
+		perNodePool:   opts.MaxRolloutsPerNodePool,
+		nodePoolLabel: opts.NodePoolLabel,
+		jitter:        opts.RolloutJitter,
+		namespaces:    map[string]int{},
+		pools:         map[string]int{},
+	}
+}
+
+// enabled returns true if any limit is set, rollouts are only tracked until completed when enabled.
+func (b *rolloutBudget) enabled() bool {
+	return b.global > 0 || b.perNS > 0 || b.perNodePool > 0
+}
+
+// acquire waits until the batch fits in the budget.
+func (b *rolloutBudget) acquire(ctx context.Context, batch *targetBatch) error {
+	b.enqueue(batch)
+	select {
+	case <-batch.admitted:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		select {
+		case <-batch.admitted:
+			b.releaseLocked(batch)
+		default:
+			for i := range b.waiting {
+				if b.waiting[i] == batch {
+					b.waiting = append(b.waiting[:i], b.waiting[i+1:]...)
+					break
+				}
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// enqueue adds the batch to the waiting queue after batches with the same or higher priority, admitted of the
+// batch is closed once it fits.
+func (b *rolloutBudget) enqueue(batch *targetBatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch.admitted = make(chan struct{})
+	i := len(b.waiting)
+	for i > 0 && b.waiting[i-1].priority < batch.priority {
+		i--
+	}
+	b.waiting = append(b.waiting, nil)
+	copy(b.waiting[i+1:], b.waiting[i:])
+	b.waiting[i] = batch
+	b.dispatch()
+}
+
+// release returns the budget taken by the batch.
+func (b *rolloutBudget) release(batch *targetBatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.releaseLocked(batch)
+}
+
+func (b *rolloutBudget) releaseLocked(batch *targetBatch) {
+	b.running--
+	b.namespaces[batch.namespace]--
+	for _, p := range batch.pools {
+		b.pools[p]--
+	}
+	b.dispatch()
+}
+
+// dispatch admits waiting batches which fit in the budget in order, must be called with mu held.
+func (b *rolloutBudget) dispatch() {
+	var rest []*targetBatch
+	for _, batch := range b.waiting {
+		if !b.fits(batch) {
+			rest = append(rest, batch)
+			continue
+		}
+		b.running++
+		b.namespaces[batch.namespace]++
+		for _, p := range batch.pools {
+			b.pools[p]++
+		}
+		close(batch.admitted)
+	}
+	b.waiting = rest
+}
+
+func (b *rolloutBudget) fits(batch *targetBatch) bool {
+	if b.global > 0 && b.running >= b.global {
+		return false
+	}
+	if b.perNS > 0 && b.namespaces[batch.namespace] >= b.perNS {
+		return false
+	}
+	for _, p := range batch.pools {
+		if b.perNodePool > 0 && b.pools[p] >= b.perNodePool {
+			return false
+		}
+	}
+	return true
+}
+
+// position returns the 1-based position of the batch in the waiting queue, or 0 if it is not waiting.
+func (b *rolloutBudget) position(batch *targetBatch) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.waiting {
+		if b.waiting[i] == batch {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// startDelay returns a random delay within the jitter, so admitted rollouts do not start at the same time.
+func (b *rolloutBudget) startDelay() time.Duration {
+	if b.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(b.jitter)))
+}
+
+// nodePools returns node pools of the workload, from nodeSelector of its pod template, or from cached nodes of its
+// current pods.
+func (t *DefaultTrigger) nodePools(obj *unstructured.Unstructured, templatePath []string) []string {
+	label := t.budget.nodePoolLabel
+	if t.budget.perNodePool <= 0 || label == "" {
+		return nil
+	}
+	if pool, found, _ := unstructured.NestedString(obj.Object, append(templatePath, "spec", "nodeSelector", label)...); found && pool != "" {
+		return []string{pool}
+	}
+
+	selector, err := workloadSelector(obj)
+	if err != nil {
+		return nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil
+	}
+	if !cache.WaitForCacheSync(t.ctx.Done(), t.nodesSynced) {
+		return nil
+	}
+	pods, err := t.client.CoreV1().Pods(obj.GetNamespace()).List(metav1.ListOptions{LabelSelector: s.String()})
+	if err != nil {
+		t.logger.Error(err, "err list pods for node pools", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		return nil
+	}
+	set := map[string]bool{}
+	nodes := map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || nodes[pod.Spec.NodeName] {
+			continue
+		}
+		nodes[pod.Spec.NodeName] = true
+		node, err := t.nodes.Get(pod.Spec.NodeName)
+		if err != nil {
+			t.logger.Error(err, "err get node", "node", pod.Spec.NodeName)
+			continue
+		}
+		if pool := node.Labels[label]; pool != "" {
+			set[pool] = true
+		}
+	}
+	var pools []string
+	for p := range set {
+		pools = append(pools, p)
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+// setQueuedRollout sets the position of the rollout of ref in list, the rollout is removed if position is 0.
+func setQueuedRollout(list *[]appv1alpha1.QueuedRollout, ref *corev1.ObjectReference, position int) {
+	for i := range *list {
+		cur := &(*list)[i]
+		if cur.ObjectRef.Kind != ref.Kind || cur.ObjectRef.Namespace != ref.Namespace || cur.ObjectRef.Name != ref.Name {
+			continue
+		}
+		if position == 0 {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+		} else {
+			cur.Position = int32(position)
+		}
+		return
+	}
+	if position > 0 {
+		*list = append(*list, appv1alpha1.QueuedRollout{ObjectRef: *ref, Position: int32(position), Since: metav1.Now()})
+	}
+}
@@ -0,0 +1,66 @@
+package trigger
+
+import (
+	"testing"
+)
+
+func TestRolloutBudget(t *testing.T) {
+	b := newRolloutBudget(Options{MaxRollouts: 2, MaxRolloutsPerNamespace: 1})
+	admitted := func(batch *targetBatch) bool {
+		select {
+		case <-batch.admitted:
+			return true
+		default:
+			return false
+		}
+	}
+	enqueue := func(namespace string) *targetBatch {
+		batch := &targetBatch{namespace: namespace}
+		b.enqueue(batch)
+		return batch
+	}
+
+	a1 := enqueue("a")
+	a2 := enqueue("a")
+	b1 := enqueue("b")
+	c1 := enqueue("c")
+	if !admitted(a1) || admitted(a2) || !admitted(b1) || admitted(c1) {
+		t.Fatalf("expect a1 and b1 admitted")
+	}
+	if p := b.position(a2); p != 1 {
+		t.Errorf("expect a2 at position 1, got %d", p)
+	}
+	if p := b.position(c1); p != 2 {
+		t.Errorf("expect c1 at position 2, got %d", p)
+	}
+
+	// a2 is still limited by its namespace, c1 is admitted though queued after a2.
+	b.release(b1)
+	if admitted(a2) || !admitted(c1) {
+		t.Errorf("expect c1 admitted after b1 released")
+	}
+	b.release(a1)
+	if !admitted(a2) {
+		t.Errorf("expect a2 admitted after a1 released")
+	}
+}
+
+func TestRolloutBudgetPriority(t *testing.T) {
+	b := newRolloutBudget(Options{MaxRollouts: 1})
+	running := &targetBatch{}
+	b.enqueue(running)
+	low := &targetBatch{}
+	b.enqueue(low)
+	high := &targetBatch{priority: 100}
+	b.enqueue(high)
+
+	if p := b.position(high); p != 1 {
+		t.Errorf("expect high priority rollout at position 1, got %d", p)
+	}
+	b.release(running)
+	select {
+	case <-high.admitted:
+	default:
+		t.Errorf("expect high priority rollout admitted first")
+	}
+}
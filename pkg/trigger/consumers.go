@@ -0,0 +1,180 @@
+package trigger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// sourceReference is a reference of a ConfigMap or Secret in a pod spec.
+type sourceReference struct {
+	Kind string
+	Name string
+	// Via is how the source is consumed, one of volume, projected volume, envFrom and env.
+	Via string
+	// Volume is name of the volume for volume references.
+	Volume string
+	// Container is name of the container for envFrom and env references.
+	Container string
+	// set replaces the name in the pod spec.
+	set func(name string)
+}
+
+// visitReferences calls fn for each reference of ConfigMaps and Secrets in volumes, projected volumes, envFrom
+// and env of podSpec.
+func visitReferences(podSpec map[string]interface{}, fn func(ref *sourceReference)) {
+	visit := func(v interface{}, kind, field string, ref sourceReference) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		name, ok := m[field].(string)
+		if !ok {
+			return
+		}
+		ref.Kind, ref.Name = kind, name
+		ref.set = func(name string) { m[field] = name }
+		fn(&ref)
+	}
+
+	for _, v := range sliceField(podSpec, "volumes") {
+		vol := mapValue(v)
+		name, _ := vol["name"].(string)
+		visit(vol["configMap"], "ConfigMap", "name", sourceReference{Via: "volume", Volume: name})
+		visit(vol["secret"], "Secret", "secretName", sourceReference{Via: "volume", Volume: name})
+		for _, s := range sliceField(mapValue(vol["projected"]), "sources") {
+			visit(mapValue(s)["configMap"], "ConfigMap", "name", sourceReference{Via: "projected volume", Volume: name})
+			visit(mapValue(s)["secret"], "Secret", "name", sourceReference{Via: "projected volume", Volume: name})
+		}
+	}
+
+	for _, c := range containers(podSpec) {
+		container := mapValue(c)
+		name, _ := container["name"].(string)
+		for _, e := range sliceField(container, "envFrom") {
+			visit(mapValue(e)["configMapRef"], "ConfigMap", "name", sourceReference{Via: "envFrom", Container: name})
+			visit(mapValue(e)["secretRef"], "Secret", "name", sourceReference{Via: "envFrom", Container: name})
+		}
+		for _, e := range sliceField(container, "env") {
+			valueFrom := mapValue(mapValue(e)["valueFrom"])
+			visit(valueFrom["configMapKeyRef"], "ConfigMap", "name", sourceReference{Via: "env", Container: name})
+			visit(valueFrom["secretKeyRef"], "Secret", "name", sourceReference{Via: "env", Container: name})
+		}
+	}
+}
+
+// containers returns init containers, containers and ephemeral containers of podSpec.
+func containers(podSpec map[string]interface{}) []interface{} {
+	var all []interface{}
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		all = append(all, sliceField(podSpec, field)...)
+	}
+	return all
+}
+
+// volumeMounts returns names of containers mounting the volume, and names of those mounting it with subPath.
+func volumeMounts(podSpec map[string]interface{}, volume string) (mounted, subPath []string) {
+	for _, c := range containers(podSpec) {
+		container := mapValue(c)
+		name, _ := container["name"].(string)
+		for _, m := range sliceField(container, "volumeMounts") {
+			mount := mapValue(m)
+			if mount["name"] != volume {
+				continue
+			}
+			mounted = append(mounted, name)
+			if p, _ := mount["subPath"].(string); p != "" {
+				subPath = append(subPath, name)
+			} else if p, _ := mount["subPathExpr"].(string); p != "" {
+				subPath = append(subPath, name)
+			}
+		}
+	}
+	return mounted, subPath
+}
+
+func mapValue(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func sliceField(m map[string]interface{}, field string) []interface{} {
+	s, _ := m[field].([]interface{})
+	return s
+}
+
+// reloadMode is how a workload picks up changes of sources.
+type reloadMode int
+
+const (
+	// reloadNone means no source is consumed by the workload.
+	reloadNone reloadMode = iota
+	// reloadInPlace means sources are only consumed by volumes, which are refreshed by kubelet.
+	reloadInPlace
+	// reloadRestart means some source is consumed by env, envFrom or subPath mounts, which never change in a
+	// running container.
+	reloadRestart
+)
+
+// decideReload inspects how podSpec consumes sources, which are keyed by kind then name, and returns the reload
+// mode with an explanation.
+func decideReload(podSpec map[string]interface{}, sources map[string]map[string]bool) (reloadMode, string) {
+	restart, inPlace := map[string]bool{}, map[string]bool{}
+	visitReferences(podSpec, func(ref *sourceReference) {
+		if !sources[ref.Kind][ref.Name] {
+			return
+		}
+		src := ref.Kind + " " + ref.Name
+		if ref.Container != "" {
+			restart[fmt.Sprintf("%v is consumed by %v of container %v", src, ref.Via, ref.Container)] = true
+			return
+		}
+		mounted, subPath := volumeMounts(podSpec, ref.Volume)
+		switch {
+		case len(subPath) > 0:
+			restart[fmt.Sprintf("%v is mounted with subPath by container %v", src, strings.Join(subPath, ","))] = true
+		case len(mounted) > 0:
+			inPlace[fmt.Sprintf("%v is mounted by %v %v", src, ref.Via, ref.Volume)] = true
+		}
+	})
+	switch {
+	case len(restart) > 0:
+		return reloadRestart, "restart required: " + joinSet(restart)
+	case len(inPlace) > 0:
+		return reloadInPlace, "reload in place: " + joinSet(inPlace)
+	default:
+		return reloadNone, "no source is consumed by the workload"
+	}
+}
+
+// joinSet joins elements of set in order.
+func joinSet(set map[string]bool) string {
+	var elems []string
+	for e := range set {
+		elems = append(elems, e)
+	}
+	sort.Strings(elems)
+	return strings.Join(elems, "; ")
+}
+
+// PodSpecSources returns ConfigMaps and Secrets referenced in volumes, projected volumes, envFrom and env of spec,
+// namespace of the references is not set.
+func PodSpecSources(spec *corev1.PodSpec) ([]corev1.ObjectReference, error) {
+	podSpec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(spec)
+	if err != nil {
+		return nil, fmt.Errorf("err convert pod spec: %w", err)
+	}
+	seen := map[corev1.ObjectReference]bool{}
+	var refs []corev1.ObjectReference
+	visitReferences(podSpec, func(ref *sourceReference) {
+		r := corev1.ObjectReference{Kind: ref.Kind, Name: ref.Name}
+		if !seen[r] {
+			seen[r] = true
+			refs = append(refs, r)
+		}
+	})
+	return refs, nil
+}
@@ -0,0 +1,54 @@
+package trigger
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecideReload(t *testing.T) {
+	sources := map[string]map[string]bool{
+		"ConfigMap": {"cm": true},
+		"Secret":    {"sc": true},
+	}
+	cases := []struct {
+		name    string
+		podSpec string
+		mode    reloadMode
+		explain string
+	}{
+		{
+			name:    "volume",
+			podSpec: `{"volumes":[{"name":"config","configMap":{"name":"cm"}}],"containers":[{"name":"app","volumeMounts":[{"name":"config","mountPath":"/etc/config"}]}]}`,
+			mode:    reloadInPlace,
+			explain: "reload in place: ConfigMap cm is mounted by volume config",
+		},
+		{
+			name:    "subPath",
+			podSpec: `{"volumes":[{"name":"config","projected":{"sources":[{"secret":{"name":"sc"}}]}}],"containers":[{"name":"app","volumeMounts":[{"name":"config","mountPath":"/etc/app.conf","subPath":"app.conf"}]}]}`,
+			mode:    reloadRestart,
+			explain: "restart required: Secret sc is mounted with subPath by container app",
+		},
+		{
+			name:    "envFrom",
+			podSpec: `{"volumes":[{"name":"config","configMap":{"name":"cm"}}],"containers":[{"name":"app","envFrom":[{"configMapRef":{"name":"cm"}}],"volumeMounts":[{"name":"config","mountPath":"/etc/config"}]}]}`,
+			mode:    reloadRestart,
+			explain: "restart required: ConfigMap cm is consumed by envFrom of container app",
+		},
+		{
+			name:    "unmounted volume and other sources",
+			podSpec: `{"volumes":[{"name":"config","configMap":{"name":"cm"}}],"containers":[{"name":"app","env":[{"name":"X","valueFrom":{"secretKeyRef":{"name":"other","key":"x"}}}]}]}`,
+			mode:    reloadNone,
+			explain: "no source is consumed by the workload",
+		},
+	}
+	for _, c := range cases {
+		podSpec := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(c.podSpec), &podSpec); err != nil {
+			t.Fatal(err)
+		}
+		mode, explain := decideReload(podSpec, sources)
+		if mode != c.mode || explain != c.explain {
+			t.Errorf("%v: expect %v %q, got %v %q", c.name, c.mode, c.explain, mode, explain)
+		}
+	}
+}
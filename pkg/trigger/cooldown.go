@@ -0,0 +1,66 @@
+package trigger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// deferredError is returned by actions which can not be done until a later time, e.g. in cooldown. Deferred
+// actions are not failed, the rule is processed again when they can be done.
+type deferredError struct {
+	until  time.Time
+	reason string
+}
+
+func (e *deferredError) Error() string {
+	return fmt.Sprintf("%v until %v", e.reason, e.until.Format(time.RFC3339))
+}
+
+// isDeferred returns the deferredError in the chain of err.
+func isDeferred(err error) (*deferredError, bool) {
+	var d *deferredError
+	return d, errors.As(err, &d)
+}
+
+// earlier returns the deferredError expiring first, either may be nil.
+func earlier(a, b *deferredError) *deferredError {
+	if a == nil || (b != nil && b.until.Before(a.until)) {
+		return b
+	}
+	return a
+}
+
+// lastRollout returns the latest LastUpdateTime of records of all rules in annotations.
+func lastRollout(annotations map[string]string) time.Time {
+	var last int64
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, RecordKeyPrefix) {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal([]byte(v), rec); err != nil {
+			// Not a record, e.g. annotations of other features sharing the prefix.
+			continue
+		}
+		if rec.LastUpdateTime > last {
+			last = rec.LastUpdateTime
+		}
+	}
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// cooldownUntil returns when the cooldown after the last rollout recorded in annotations expires, it is in the
+// past if the workload is not in cooldown.
+func cooldownUntil(annotations map[string]string, cooldown time.Duration) time.Time {
+	last := lastRollout(annotations)
+	if last.IsZero() {
+		return last
+	}
+	return last.Add(cooldown)
+}
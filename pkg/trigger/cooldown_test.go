@@ -0,0 +1,90 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCooldownUntil(t *testing.T) {
+	last := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	annotations := map[string]string{
+		VolumeRefreshAnnotation: "2019-06-02T00:00:00Z",
+		"app":                   "foo",
+	}
+	for i, rec := range []*Record{
+		{LastUpdateTime: last.Add(-time.Hour).UnixNano()},
+		{LastUpdateTime: last.UnixNano()},
+	} {
+		val, _ := json.Marshal(rec)
+		annotations[GetRecordKey(string(rune('a'+i)), "foo-ns")] = string(val)
+	}
+
+	if got := cooldownUntil(annotations, time.Minute); !got.Equal(last.Add(time.Minute)) {
+		t.Errorf("expect cooldown until %v, got %v", last.Add(time.Minute), got)
+	}
+	if got := cooldownUntil(nil, time.Minute); !got.IsZero() {
+		t.Errorf("expect no cooldown without records, got %v", got)
+	}
+}
+
+func TestDoRequeuesDeferredActions(t *testing.T) {
+	last, _ := json.Marshal(&Record{
+		LastUpdateTime: time.Now().Add(-time.Minute).UnixNano(),
+		Sources:        []Source{{Name: "foo", Namespace: "foo-ns", Kind: "ConfigMap", ResourceVersion: "1"}},
+	})
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns"},
+		"spec": map[string]interface{}{"template": map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": map[string]interface{}{GetRecordKey("foo", "foo-ns"): string(last)}},
+		}},
+	}}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns", ResourceVersion: "2"}}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	timeout := int64(1)
+	tr := New(nil, fake.NewSimpleClientset(cm), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deploy), mapper, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Sources: []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns"}}},
+			Actions: []appv1alpha1.Action{{UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{
+				ObjectRef: corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "foo-ns"},
+				Cooldown:  &metav1.Duration{Duration: time.Hour},
+			}}},
+			PostActions: []appv1alpha1.Hook{{Template: batchv1beta1.JobTemplateSpec{}, TimeoutSeconds: &timeout}},
+		},
+	}
+	after, err := tr.do(context.Background(), types.NamespacedName{Namespace: "foo-ns", Name: "foo"}, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after < 58*time.Minute || after > time.Hour {
+		t.Errorf("expect rule processed again when the cooldown expires, got %v", after)
+	}
+	jobs, err := tr.client.BatchV1().Jobs("foo-ns").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Errorf("expect post actions skipped while actions are deferred, got %d jobs", len(jobs.Items))
+	}
+}
@@ -0,0 +1,263 @@
+package trigger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// DefaultBatchWindow is the default time records for a workload are collected before they are patched together.
+const DefaultBatchWindow = 2 * time.Second
+
+// resultTTL is how long the result of a patched batch is kept for rules which have not read it.
+const resultTTL = 10 * time.Minute
+
+// targetBatch is a set of records for a workload which are patched together.
+type targetBatch struct {
+	ri           dynamic.ResourceInterface
+	name         string
+	templatePath []string
+	// surge is the first surge requested by rules in the batch.
+	surge   *appv1alpha1.Surge
+	records map[string]*Record
+	// priority is the highest priority of rules in the batch.
+	priority int32
+	// waiters are rules whose records are in the batch, they are processed again once it is patched.
+	waiters map[types.NamespacedName]bool
+
+	// namespace and pools are where the rollout takes the rollout budget, admitted is closed once it is taken.
+	namespace string
+	pools     []string
+	admitted  chan struct{}
+
+	// finished is set after the batch is patched, reason and err are the result shared by all rules in the batch.
+	finished time.Time
+	reason   string
+	err      error
+}
+
+// targetCoordinator serializes updates of pod templates per workload. Records submitted while a workload is being
+// patched, or within the batch window, are merged into one patch, so a burst of changes from several rules causes
+// a single rollout. Rules do not wait for their batches, they are processed again once the batch is patched and
+// read the result then.
+type targetCoordinator struct {
+	window time.Duration
+	mu     sync.Mutex
+	// pending are batches collecting records, keyed by workload.
+	pending map[string]*targetBatch
+	// inflight are batches being patched, including waiting for the rollout budget and the rollout, keyed by
+	// workload.
+	inflight map[string]*targetBatch
+	// results are patched batches keyed by workload then annotation key of records, until the rule reads its
+	// result.
+	results map[string]map[string]*targetBatch
+	// running are workloads which have a goroutine flushing batches.
+	running map[string]bool
+}
+
+func newTargetCoordinator(window time.Duration) *targetCoordinator {
+	return &targetCoordinator{
+		window:   window,
+		pending:  map[string]*targetBatch{},
+		inflight: map[string]*targetBatch{},
+		results:  map[string]map[string]*targetBatch{},
+		running:  map[string]bool{},
+	}
+}
+
+// submitRecord adds the record under annotationKey to the pending batch of the workload, or starts a batch with b.
+// The batch is returned without waiting for it to be patched, rule is processed again once it is.
+func (t *DefaultTrigger) submitRecord(key string, annotationKey string, rule types.NamespacedName, rec *Record, b *targetBatch) *targetBatch {
+	c := t.targets
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.results[key], annotationKey)
+	batch := c.pending[key]
+	if batch == nil {
+		batch = b
+		batch.records = map[string]*Record{}
+		batch.waiters = map[types.NamespacedName]bool{}
+		c.pending[key] = batch
+		if !c.running[key] {
+			c.running[key] = true
+			// Tracked with workers, so batches are drained when the trigger stops.
+			t.wg.Add(1)
+			go t.flushBatches(key)
+		}
+	}
+	if batch.surge == nil {
+		batch.surge = b.surge
+	}
+	if b.priority > batch.priority {
+		batch.priority = b.priority
+	}
+	batch.records[annotationKey] = rec
+	batch.waiters[rule] = true
+	return batch
+}
+
+// activeBatch returns the pending or inflight batch of the workload which holds the record under annotationKey, the
+// record must have the same sources as rec unless rec is nil. rule is processed again once the batch is patched.
+func (c *targetCoordinator) activeBatch(key string, annotationKey string, rule types.NamespacedName, rec *Record) *targetBatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, batch := range []*targetBatch{c.pending[key], c.inflight[key]} {
+		if batch == nil {
+			continue
+		}
+		if r, ok := batch.records[annotationKey]; ok && (rec == nil || r.hash() == rec.hash()) {
+			batch.waiters[rule] = true
+			return batch
+		}
+	}
+	return nil
+}
+
+// takeResult removes and returns the patched batch which held the record under annotationKey, results expired
+// are dropped.
+func (c *targetCoordinator) takeResult(key string, annotationKey string) *targetBatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, results := range c.results {
+		for ak, batch := range results {
+			if time.Since(batch.finished) > resultTTL {
+				delete(results, ak)
+			}
+		}
+		if len(results) == 0 {
+			delete(c.results, k)
+		}
+	}
+	batch := c.results[key][annotationKey]
+	delete(c.results[key], annotationKey)
+	return batch
+}
+
+// flushBatches patches pending batches of the workload one by one, until no batch is pending. Rules of each batch
+// are processed again once it is patched.
+func (t *DefaultTrigger) flushBatches(key string) {
+	defer t.wg.Done()
+	c := t.targets
+	for {
+		select {
+		case <-t.ctx.Done():
+		case <-time.After(c.window):
+		}
+
+		c.mu.Lock()
+		batch := c.pending[key]
+		delete(c.pending, key)
+		c.inflight[key] = batch
+		c.mu.Unlock()
+
+		reason, err := t.patchBatch(batch)
+
+		c.mu.Lock()
+		batch.reason, batch.err, batch.finished = reason, err, time.Now()
+		delete(c.inflight, key)
+		if c.results[key] == nil {
+			c.results[key] = map[string]*targetBatch{}
+		}
+		for annotationKey := range batch.records {
+			c.results[key][annotationKey] = batch
+		}
+		for rule := range batch.waiters {
+			t.queue.Add(rule)
+		}
+		if c.pending[key] == nil {
+			delete(c.running, key)
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+	}
+}
+
+// patchBatch sets all records of the batch to pod template of the workload in one patch, with the workload surged
+// during the rollout if requested. If the rollout budget is enabled, the patch waits for the budget, which is held
+// until the rollout completed.
+func (t *DefaultTrigger) patchBatch(batch *targetBatch) (string, error) {
+	obj, err := batch.ri.Get(batch.name, metav1.GetOptions{})
+	if err != nil {
+		return "GetFailed", fmt.Errorf("err get workload: %w", err)
+	}
+	if t.budget.enabled() {
+		batch.namespace = obj.GetNamespace()
+		batch.pools = t.nodePools(obj, batch.templatePath)
+		if err := t.budget.acquire(t.ctx, batch); err != nil {
+			return "Canceled", fmt.Errorf("err wait for rollout budget: %w", err)
+		}
+		defer t.budget.release(batch)
+	}
+	delay := t.budget.startDelay()
+	if delay > 0 {
+		select {
+		case <-t.ctx.Done():
+			return "Canceled", t.ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if batch.admitted != nil || delay > 0 {
+		// The workload may have changed while waiting.
+		if obj, err = batch.ri.Get(batch.name, metav1.GetOptions{}); err != nil {
+			return "GetFailed", fmt.Errorf("err get workload: %w", err)
+		}
+	}
+	annotations, _, err := unstructured.NestedStringMap(obj.Object, append(batch.templatePath, "metadata", "annotations")...)
+	if err != nil {
+		return "PatchFailed", fmt.Errorf("err get annotations of pod template: %w", err)
+	}
+	pt, err := generateRecordsPatch(batch.records, batch.templatePath, annotations == nil)
+	if err != nil {
+		return "PatchFailed", fmt.Errorf("err generate patch: %w", err)
+	}
+
+	var state *surgeState
+	if batch.surge != nil {
+		if state, err = t.startSurge(batch.ri, obj, batch.surge); err != nil {
+			return "SurgeFailed", fmt.Errorf("err surge workload: %w", err)
+		}
+	}
+
+	t.logger.Info("Generate patch", "patch", string(pt), "records", len(batch.records))
+	_, err = batch.ri.Patch(batch.name, types.JSONPatchType, pt, metav1.UpdateOptions{})
+	if state != nil {
+		// Restore even if the patch failed.
+		if sErr := t.finishSurge(t.ctx, batch.ri, batch.name, state, batch.surge); sErr != nil && err == nil {
+			return "SurgeFailed", sErr
+		}
+	}
+	if err != nil {
+		return "PatchFailed", fmt.Errorf("err patch workload: %w", err)
+	}
+	if t.budget.enabled() && state == nil {
+		// The budget is taken by the rollout until it completed, surged rollouts have been waited above.
+		if err := t.waitForRollout(t.ctx, batch.ri, batch.name, defaultWaitTimeout); err != nil {
+			t.logger.Error(err, "Rollout not completed, release rollout budget", "namespace", obj.GetNamespace(), "name", batch.name)
+		}
+	}
+	return "PodTemplateUpdated", nil
+}
+
+// batchMessage describes rules merged into the batch other than annotationKey.
+func batchMessage(batch *targetBatch, annotationKey string) string {
+	var others []string
+	for key := range batch.records {
+		if key != annotationKey {
+			others = append(others, strings.TrimPrefix(key, RecordKeyPrefix))
+		}
+	}
+	if len(others) == 0 {
+		return ""
+	}
+	sort.Strings(others)
+	return fmt.Sprintf("merged with %v", strings.Join(others, ", "))
+}
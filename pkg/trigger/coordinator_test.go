@@ -0,0 +1,67 @@
+package trigger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestUpdatePodTemplateDoesNotWait(t *testing.T) {
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns"},
+		"spec":       map[string]interface{}{"template": map[string]interface{}{"metadata": map[string]interface{}{}}},
+	}}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	tr := New(nil, fake.NewSimpleClientset(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deploy), mapper, nil, Options{BatchWindow: 10 * time.Millisecond}).(*DefaultTrigger)
+	defer tr.Stop()
+
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Sources: []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}}},
+			Actions: []appv1alpha1.Action{{UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{
+				ObjectRef: corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "foo-ns"},
+			}}},
+		},
+	}
+	status, err := tr.updatePodTemplate(context.Background(), rule, &rule.Spec.Actions[0])
+	if _, ok := isDeferred(err); !ok {
+		t.Fatalf("expect action deferred until the workload is patched, got %v", err)
+	}
+	if status == nil || status.Phase != appv1alpha1.ActionRunning {
+		t.Fatalf("expect running status, got %#v", status)
+	}
+
+	// The rule is added back to queue once the batch is patched, and reads the result then.
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.queue.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expect rule added to queue after the batch is patched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, err = tr.updatePodTemplate(context.Background(), rule, &rule.Spec.Actions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Phase != appv1alpha1.ActionSucceeded || status.Reason != "PodTemplateUpdated" {
+		t.Fatalf("expect result of the patched batch, got %#v", status)
+	}
+	if status, err := tr.updatePodTemplate(context.Background(), rule, &rule.Spec.Actions[0]); status != nil || err != nil {
+		t.Errorf("expect nothing done once the result is read, got %#v, %v", status, err)
+	}
+}
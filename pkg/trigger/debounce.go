@@ -0,0 +1,85 @@
+package trigger
+
+import (
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// burst is a series of changes of sources of a rule waiting for the debounce period.
+type burst struct {
+	first time.Time
+	last  time.Time
+	// hash is the hash of versions of sources at the last change.
+	hash string
+}
+
+// settle records the change of sources of rule, and returns the time to wait before executing actions, which is
+// 0 if the rule has no debounce or the burst of changes has settled. A burst is only started if sources changed
+// since the last settled burst. Pending changes are shown in status.
+func (t *DefaultTrigger) settle(key types.NamespacedName, rule *appv1alpha1.TriggerRule) time.Duration {
+	if rule.Spec.Debounce == nil {
+		return 0
+	}
+	var maxWait time.Duration
+	if rule.Spec.MaxWait != nil {
+		maxWait = rule.Spec.MaxWait.Duration
+	}
+	now := time.Now()
+	hash := sourcesHash(rule)
+
+	t.mu.Lock()
+	b := t.bursts[key]
+	if b == nil && t.settled[key] == hash {
+		// Processed again without changes, e.g. requeued for a retry or a rollout in progress.
+		t.mu.Unlock()
+		return 0
+	}
+	switch {
+	case b == nil:
+		b = &burst{first: now, last: now, hash: hash}
+		t.bursts[key] = b
+	case b.hash == "":
+		// Restored after a restart.
+		b.hash = hash
+	case b.hash != hash:
+		b.last, b.hash = now, hash
+	}
+	at := settleTime(b, rule.Spec.Debounce.Duration, maxWait)
+	settled := !now.Before(at)
+	if settled {
+		delete(t.bursts, key)
+		t.settled[key] = hash
+	}
+	t.mu.Unlock()
+
+	var pending *appv1alpha1.PendingStatus
+	if !settled {
+		pending = &appv1alpha1.PendingStatus{
+			FirstChangeTime: metav1.NewTime(b.first),
+			LastChangeTime:  metav1.NewTime(b.last),
+			ScheduledTime:   metav1.NewTime(at),
+		}
+	}
+	if err := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+		s.Pending = pending
+	}); err != nil {
+		t.logger.Error(err, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
+	}
+	if settled {
+		return 0
+	}
+	return at.Sub(now)
+}
+
+// settleTime returns when the burst settles, which is debounce after the last change, but no later than maxWait
+// after the first change if maxWait is positive.
+func settleTime(b *burst, debounce, maxWait time.Duration) time.Time {
+	at := b.last.Add(debounce)
+	if maxWait > 0 && at.After(b.first.Add(maxWait)) {
+		at = b.first.Add(maxWait)
+	}
+	return at
+}
@@ -0,0 +1,60 @@
+package trigger
+
+import (
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSettleTime(t *testing.T) {
+	first := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		last     time.Duration
+		debounce time.Duration
+		maxWait  time.Duration
+		expect   time.Duration
+	}{
+		{0, 10 * time.Second, 0, 10 * time.Second},
+		{25 * time.Second, 10 * time.Second, 0, 35 * time.Second},
+		{25 * time.Second, 10 * time.Second, 30 * time.Second, 30 * time.Second},
+		{5 * time.Second, 10 * time.Second, 30 * time.Second, 15 * time.Second},
+	}
+	for _, c := range cases {
+		b := &burst{first: first, last: first.Add(c.last)}
+		if got := settleTime(b, c.debounce, c.maxWait); !got.Equal(first.Add(c.expect)) {
+			t.Errorf("settleTime(last=%v, debounce=%v, maxWait=%v) = %v, expect %v", c.last, c.debounce, c.maxWait, got.Sub(first), c.expect)
+		}
+	}
+}
+
+func TestSettleSameSources(t *testing.T) {
+	tr := New(nil, nil, nil, nil, nil, Options{}).(*DefaultTrigger)
+	key := types.NamespacedName{Namespace: "foo-ns", Name: "foo"}
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Sources:  []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}}},
+			Debounce: &metav1.Duration{Duration: 50 * time.Millisecond},
+		},
+	}
+
+	if wait := tr.settle(key, rule); wait <= 0 {
+		t.Fatalf("expect change debounced, got %v", wait)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if wait := tr.settle(key, rule); wait != 0 {
+		t.Fatalf("expect burst settled, got %v", wait)
+	}
+	// Processed again with the same sources, e.g. for a retry.
+	if wait := tr.settle(key, rule); wait != 0 {
+		t.Errorf("expect no debounce without changes since the last burst, got %v", wait)
+	}
+	rule.Spec.Sources[0].ObjectRef.ResourceVersion = "2"
+	if wait := tr.settle(key, rule); wait <= 0 {
+		t.Errorf("expect new change debounced, got %v", wait)
+	}
+}
@@ -0,0 +1,219 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// defaultDiscoveryKinds are kinds of workloads discovered if kinds are not specified.
+var defaultDiscoveryKinds = []string{"Deployment", "StatefulSet", "DaemonSet"}
+
+// discover applies the discovery mode to workloads consuming sources, and records them in status. Workloads found
+// for the first time without sources changed are only recorded.
+func (t *DefaultTrigger) discover(ctx context.Context, rule *appv1alpha1.TriggerRule) error {
+	spec := rule.Spec.Discover
+	mode := spec.Mode
+	if mode == "" {
+		mode = appv1alpha1.DiscoveryAuto
+	}
+	if mode != appv1alpha1.DiscoveryAuto && mode != appv1alpha1.DiscoveryRestart {
+		return fmt.Errorf("unsupported discovery mode %v", mode)
+	}
+
+	targets, err := t.discoverTargets(rule, spec)
+	if err != nil {
+		return err
+	}
+	// Status of rule may be stale, previous results are read from the latest status.
+	latest, err := t.latestStatus(rule)
+	if err != nil {
+		return err
+	}
+	hash := sourcesHash(rule)
+	prev := latest.Discovery
+	if prev == nil {
+		prev = &appv1alpha1.DiscoveryStatus{}
+	}
+	sourcesChanged := latest.Discovery == nil || prev.SourcesHash != hash
+
+	var results []appv1alpha1.ActionStatus
+	var errs []string
+	var deferred *deferredError
+	for i := range targets {
+		ref := &targets[i]
+		last := findTargetStatus(prev.Targets, ref)
+		if last == nil && !sourcesChanged {
+			results = append(results, appv1alpha1.ActionStatus{
+				Phase:     appv1alpha1.ActionSucceeded,
+				Reason:    "Discovered",
+				Message:   "discovered without sources changed",
+				ObjectRef: ref,
+			})
+			continue
+		}
+
+		var status *appv1alpha1.ActionStatus
+		switch mode {
+		case appv1alpha1.DiscoveryAuto:
+			status, err = t.auto(ctx, rule, &appv1alpha1.Action{Auto: &appv1alpha1.ActionAuto{ObjectRef: *ref}})
+		case appv1alpha1.DiscoveryRestart:
+			status, err = t.updatePodTemplate(ctx, rule, &appv1alpha1.Action{UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{ObjectRef: *ref}})
+		}
+		if d, ok := isDeferred(err); ok {
+			deferred = earlier(deferred, d)
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			if status == nil {
+				status = &appv1alpha1.ActionStatus{Phase: appv1alpha1.ActionFailed, Reason: "Error", Message: err.Error()}
+			}
+		}
+		switch {
+		case status != nil:
+		case last != nil:
+			status = last.DeepCopy()
+		default:
+			status = &appv1alpha1.ActionStatus{Phase: appv1alpha1.ActionSucceeded, Reason: "Discovered"}
+		}
+		status.ObjectRef = ref
+		results = append(results, *status)
+	}
+
+	if sErr := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+		if s.Discovery == nil {
+			s.Discovery = &appv1alpha1.DiscoveryStatus{}
+		}
+		s.Discovery.SourcesHash = hash
+		s.Discovery.Targets = mergeTargetStatus(s.Discovery.Targets, results)
+	}); sErr != nil {
+		t.logger.Error(sErr, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("err apply actions to discovered workloads: %v", strings.Join(errs, "; "))
+	}
+	if deferred != nil {
+		// Workloads being rolled out are checked again when the earliest of them is due.
+		return deferred
+	}
+	return nil
+}
+
+// discoverTargets lists workloads in namespaces of sources which reference any source.
+func (t *DefaultTrigger) discoverTargets(rule *appv1alpha1.TriggerRule, spec *appv1alpha1.Discovery) ([]corev1.ObjectReference, error) {
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultDiscoveryKinds
+	}
+	listOptions := metav1.ListOptions{}
+	if spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("err parse selector: %w", err)
+		}
+		listOptions.LabelSelector = selector.String()
+	}
+
+	// Sources keyed by namespace, kind then name.
+	sources := map[string]map[string]map[string]bool{}
+	for _, src := range rule.Spec.Sources {
+		ref := src.ObjectRef
+		if sources[ref.Namespace] == nil {
+			sources[ref.Namespace] = map[string]map[string]bool{}
+		}
+		if sources[ref.Namespace][ref.Kind] == nil {
+			sources[ref.Namespace][ref.Kind] = map[string]bool{}
+		}
+		sources[ref.Namespace][ref.Kind][ref.Name] = true
+	}
+	var namespaces []string
+	for ns := range sources {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	var targets []corev1.ObjectReference
+	for _, ns := range namespaces {
+		for _, kind := range kinds {
+			ri, mapping, err := t.resourceFor(&corev1.ObjectReference{Kind: kind, Namespace: ns})
+			if err != nil {
+				return nil, err
+			}
+			templatePath, err := podTemplatePath(mapping.GroupVersionKind.GroupKind(), "")
+			if err != nil {
+				return nil, err
+			}
+			list, err := ri.List(listOptions)
+			if err != nil {
+				return nil, fmt.Errorf("err list %v: %w", kind, err)
+			}
+			for _, item := range list.Items {
+				podSpec, _, _ := unstructured.NestedMap(item.Object, append(templatePath, "spec")...)
+				referenced := false
+				visitReferences(podSpec, func(ref *sourceReference) {
+					referenced = referenced || sources[ns][ref.Kind][ref.Name]
+				})
+				if !referenced {
+					continue
+				}
+				targets = append(targets, corev1.ObjectReference{
+					APIVersion: mapping.GroupVersionKind.GroupVersion().String(),
+					Kind:       kind,
+					Namespace:  ns,
+					Name:       item.GetName(),
+				})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// sourcesHash returns the hash of versions of sources of rule.
+func sourcesHash(rule *appv1alpha1.TriggerRule) string {
+	rec := &Record{}
+	for _, src := range rule.Spec.Sources {
+		rec.Sources = append(rec.Sources, Source{
+			Name:            src.ObjectRef.Name,
+			Namespace:       src.ObjectRef.Namespace,
+			Kind:            src.ObjectRef.Kind,
+			ResourceVersion: src.ObjectRef.ResourceVersion,
+		})
+	}
+	return rec.hash()
+}
+
+// findTargetStatus returns the status of the workload in list.
+func findTargetStatus(list []appv1alpha1.ActionStatus, ref *corev1.ObjectReference) *appv1alpha1.ActionStatus {
+	for i := range list {
+		if r := list[i].ObjectRef; r != nil && r.Kind == ref.Kind && r.Namespace == ref.Namespace && r.Name == ref.Name {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// mergeTargetStatus returns results indexed in order, keeping time of results not changed since list.
+func mergeTargetStatus(list, results []appv1alpha1.ActionStatus) []appv1alpha1.ActionStatus {
+	var merged []appv1alpha1.ActionStatus
+	for i, as := range results {
+		as.Index = i
+		as.LastUpdateTime = metav1.Now()
+		if last := findTargetStatus(list, as.ObjectRef); last != nil {
+			cur := *last
+			cur.Index, cur.LastUpdateTime = as.Index, as.LastUpdateTime
+			if reflect.DeepEqual(cur, as) {
+				as.LastUpdateTime = last.LastUpdateTime
+			}
+		}
+		merged = append(merged, as)
+	}
+	return merged
+}
@@ -0,0 +1,33 @@
+package trigger
+
+import (
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergeTargetStatus(t *testing.T) {
+	before := metav1.NewTime(time.Now().Add(-time.Hour))
+	list := []appv1alpha1.ActionStatus{
+		{Index: 0, Phase: appv1alpha1.ActionSucceeded, Reason: "Discovered", ObjectRef: &corev1.ObjectReference{Kind: "Deployment", Name: "a"}, LastUpdateTime: before},
+		{Index: 1, Phase: appv1alpha1.ActionSucceeded, Reason: "Discovered", ObjectRef: &corev1.ObjectReference{Kind: "Deployment", Name: "b"}, LastUpdateTime: before},
+	}
+	results := []appv1alpha1.ActionStatus{
+		{Phase: appv1alpha1.ActionSucceeded, Reason: "Discovered", ObjectRef: &corev1.ObjectReference{Kind: "Deployment", Name: "b"}},
+		{Phase: appv1alpha1.ActionSucceeded, Reason: "PodTemplateUpdated", ObjectRef: &corev1.ObjectReference{Kind: "Deployment", Name: "c"}},
+	}
+
+	merged := mergeTargetStatus(list, results)
+	if len(merged) != 2 {
+		t.Fatalf("Expect 2 targets, got %v", len(merged))
+	}
+	if merged[0].Index != 0 || merged[0].ObjectRef.Name != "b" || !merged[0].LastUpdateTime.Equal(&before) {
+		t.Errorf("Expect unchanged target to keep its time, got %#v", merged[0])
+	}
+	if merged[1].Index != 1 || merged[1].ObjectRef.Name != "c" || merged[1].LastUpdateTime.Equal(&before) {
+		t.Errorf("Expect new target with new time, got %#v", merged[1])
+	}
+}
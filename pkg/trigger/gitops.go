@@ -0,0 +1,190 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// FluxReconcileRequestAnnotation requests Flux to reconcile an object out of its interval.
+	FluxReconcileRequestAnnotation = "reconcile.fluxcd.io/requestedAt"
+	// ArgoCDRefreshAnnotation requests Argo CD to refresh an Application.
+	ArgoCDRefreshAnnotation = "argocd.argoproj.io/refresh"
+)
+
+func (t *DefaultTrigger) fluxReconcile(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.Flux
+	ref := &spec.ObjectRef
+
+	ri, rec, err := t.recordOnObject(rule, ref, "")
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	requestedAt := time.Now().Format(time.RFC3339Nano)
+	if err := t.patchRecord(ri, rule, ref.Name, rec, map[string]interface{}{
+		FluxReconcileRequestAnnotation: requestedAt,
+	}); err != nil {
+		return nil, err
+	}
+	t.logger.Info("Request flux reconciliation", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name)
+
+	status := &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    "ReconcileRequested",
+		ObjectRef: ref.DeepCopy(),
+	}
+	if !spec.WaitForReady {
+		return status, nil
+	}
+
+	obj, err := pollObject(ctx, ri, ref.Name, waitTimeout(spec.TimeoutSeconds), func(obj *unstructured.Unstructured) (bool, error) {
+		handled, _, _ := unstructured.NestedString(obj.Object, "status", "lastHandledReconcileAt")
+		if handled != requestedAt {
+			return false, nil
+		}
+		ready := findCondition(obj, "Ready")
+		return ready != nil && ready["status"] != "Unknown", nil
+	})
+	if err != nil {
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "WaitFailed"
+		status.Message = err.Error()
+		return status, fmt.Errorf("err wait for reconciliation of %v %s/%s: %w", ref.Kind, ref.Namespace, ref.Name, err)
+	}
+
+	ready := findCondition(obj, "Ready")
+	status.Reason, _ = ready["reason"].(string)
+	status.Message, _ = ready["message"].(string)
+	if ready["status"] != "True" {
+		status.Phase = appv1alpha1.ActionFailed
+		return status, fmt.Errorf("%v %s/%s is not ready: %v", ref.Kind, ref.Namespace, ref.Name, status.Message)
+	}
+	return status, nil
+}
+
+func (t *DefaultTrigger) argoCD(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.ArgoCD
+	ref := &spec.ObjectRef
+
+	ri, rec, err := t.recordOnObject(rule, ref, "")
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	refresh := spec.Refresh
+	if refresh == "" {
+		refresh = appv1alpha1.ArgoCDRefreshNormal
+	}
+	if refresh != appv1alpha1.ArgoCDRefreshNormal && refresh != appv1alpha1.ArgoCDRefreshHard {
+		return nil, fmt.Errorf("unsupported refresh type %v", refresh)
+	}
+	if err := t.patchRecord(ri, rule, ref.Name, rec, map[string]interface{}{
+		ArgoCDRefreshAnnotation: string(refresh),
+	}); err != nil {
+		return nil, err
+	}
+	t.logger.Info("Refresh argocd application", "namespace", ref.Namespace, "name", ref.Name, "sync", spec.Sync)
+
+	// Argo CD records the start time of operations in seconds.
+	requestedAt := time.Now().Truncate(time.Second)
+	if spec.Sync {
+		// Same as the operation created by "argocd app sync".
+		sync := map[string]interface{}{"revision": ""}
+		if spec.Prune {
+			sync["prune"] = true
+		}
+		if err := mergePatch(ri, ref.Name, map[string]interface{}{
+			"operation": map[string]interface{}{
+				"initiatedBy": map[string]interface{}{"username": FieldManager},
+				"sync":        sync,
+			},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	status := &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    "RefreshRequested",
+		ObjectRef: ref.DeepCopy(),
+	}
+	if spec.Sync {
+		status.Reason = "SyncRequested"
+	}
+	if !spec.WaitForCompletion {
+		return status, nil
+	}
+
+	obj, err := pollObject(ctx, ri, ref.Name, waitTimeout(spec.TimeoutSeconds), func(obj *unstructured.Unstructured) (bool, error) {
+		if _, refreshing := obj.GetAnnotations()[ArgoCDRefreshAnnotation]; refreshing {
+			return false, nil
+		}
+		if !spec.Sync {
+			return true, nil
+		}
+		if _, found, _ := unstructured.NestedMap(obj.Object, "operation"); found {
+			return false, nil
+		}
+		if !syncStartedBy(obj, requestedAt) {
+			// The operation state is left by an earlier sync.
+			return false, nil
+		}
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "operationState", "phase")
+		return phase != "" && phase != "Running" && phase != "Terminating", nil
+	})
+	if err != nil {
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "WaitFailed"
+		status.Message = err.Error()
+		return status, fmt.Errorf("err wait for application %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	syncStatus, _, _ := unstructured.NestedString(obj.Object, "status", "sync", "status")
+	health, _, _ := unstructured.NestedString(obj.Object, "status", "health", "status")
+	status.Message = fmt.Sprintf("sync status %v, health %v", syncStatus, health)
+	if !spec.Sync {
+		status.Reason = "Refreshed"
+		return status, nil
+	}
+
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "operationState", "phase")
+	message, _, _ := unstructured.NestedString(obj.Object, "status", "operationState", "message")
+	status.Reason = "Sync" + phase
+	if message != "" {
+		status.Message = message + ", " + status.Message
+	}
+	if phase != "Succeeded" {
+		status.Phase = appv1alpha1.ActionFailed
+		return status, fmt.Errorf("sync of application %s/%s %v: %v", ref.Namespace, ref.Name, phase, message)
+	}
+	return status, nil
+}
+
+// syncStartedBy returns true if the operation state of the Application is of a sync initiated by kube-trigger and
+// started at or after since.
+func syncStartedBy(obj *unstructured.Unstructured, since time.Time) bool {
+	username, _, _ := unstructured.NestedString(obj.Object, "status", "operationState", "operation", "initiatedBy", "username")
+	if username != FieldManager {
+		return false
+	}
+	startedAt, _, _ := unstructured.NestedString(obj.Object, "status", "operationState", "startedAt")
+	started, err := time.Parse(time.RFC3339, startedAt)
+	return err == nil && !started.Before(since)
+}
+
+// findCondition returns the condition of type in status.conditions.
+func findCondition(obj *unstructured.Unstructured, condType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if ok && cond["type"] == condType {
+			return cond
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package trigger
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSyncStartedBy(t *testing.T) {
+	requestedAt := time.Date(2019, 6, 11, 8, 0, 0, 0, time.UTC)
+	operationState := func(username, startedAt string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"status": map[string]interface{}{
+				"operationState": map[string]interface{}{
+					"phase":     "Succeeded",
+					"startedAt": startedAt,
+					"operation": map[string]interface{}{
+						"initiatedBy": map[string]interface{}{"username": username},
+					},
+				},
+			},
+		}}
+	}
+	cases := []struct {
+		name   string
+		obj    *unstructured.Unstructured
+		expect bool
+	}{
+		{name: "requested sync", obj: operationState(FieldManager, "2019-06-11T08:00:00Z"), expect: true},
+		{name: "earlier sync", obj: operationState(FieldManager, "2019-06-11T07:59:59Z"), expect: false},
+		{name: "sync of others", obj: operationState("admin", "2019-06-11T08:00:01Z"), expect: false},
+		{name: "no operation", obj: &unstructured.Unstructured{Object: map[string]interface{}{}}, expect: false},
+	}
+	for _, c := range cases {
+		if got := syncStartedBy(c.obj, requestedAt); got != c.expect {
+			t.Errorf("%v: expect %v, got %v", c.name, c.expect, got)
+		}
+	}
+}
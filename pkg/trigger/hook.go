@@ -0,0 +1,115 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// HookLabel is added to Jobs created by hooks, value is phase and index of the hook, e.g. "pre-0".
+const HookLabel = "trigger.app.example.com/hook"
+
+const (
+	hookPre  = "pre"
+	hookPost = "post"
+)
+
+// runHooks runs hooks of phase in order, and stops at the first failure. Attempts of hooks failed for the current
+// sources are read from latest, a failed hook is run again once its backoff passed or a retry is requested, until it
+// is exhausted. The delay before the next attempt is returned with the error.
+func (t *DefaultTrigger) runHooks(ctx context.Context, rule *appv1alpha1.TriggerRule, phase string, hooks []appv1alpha1.Hook, latest *appv1alpha1.TriggerRuleStatus, manualRetry bool) (time.Duration, error) {
+	hash := sourcesHash(rule)
+	last := latest.PreActions
+	if phase == hookPost {
+		last = latest.PostActions
+	}
+	for i := range hooks {
+		var attempts int32
+		prev := findActionStatus(last, i)
+		if prev != nil && prev.SourcesHash == hash && !manualRetry {
+			if prev.Phase == appv1alpha1.ActionExhausted {
+				return 0, fmt.Errorf("%s action %d is exhausted: %s", phase, i, prev.Message)
+			}
+			attempts = prev.Attempts
+		}
+
+		status, err := t.runHook(ctx, rule, phase, i, &hooks[i], attempts > 0 || manualRetry)
+		if err != nil && ctx.Err() != nil {
+			// Interrupted by Stop, status is left for the resumed run.
+			return 0, err
+		}
+		if err != nil && status == nil {
+			status = &appv1alpha1.ActionStatus{Phase: appv1alpha1.ActionFailed, Reason: "Error", Message: err.Error()}
+		}
+		if err == nil && status == nil && prev != nil && prev.Phase == appv1alpha1.ActionFailed {
+			// The Job completed after the failed attempt was recorded, e.g. it was run again before a restart.
+			status = &appv1alpha1.ActionStatus{Phase: appv1alpha1.ActionSucceeded, Reason: "Recovered", ObjectRef: prev.ObjectRef}
+		}
+		var delay time.Duration
+		if err != nil {
+			status.Attempts, status.SourcesHash = attempts+1, hash
+			if status.Attempts >= maxAttempts(nil) {
+				status.Phase = appv1alpha1.ActionExhausted
+			} else {
+				delay = backoff(nil, status.Attempts)
+				status.Message = fmt.Sprintf("%v, attempt %d will start in %v", status.Message, status.Attempts+1, delay)
+			}
+		}
+		if status != nil {
+			status.Index = i
+			if sErr := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+				t.setRunning(s, rule)
+				if phase == hookPre {
+					setActionStatus(&s.PreActions, *status)
+				} else {
+					setActionStatus(&s.PostActions, *status)
+				}
+			}); sErr != nil {
+				t.logger.Error(sErr, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
+			}
+		}
+		if err != nil {
+			return delay, fmt.Errorf("%s action %d: %w", phase, i, err)
+		}
+	}
+	return 0, nil
+}
+
+// runHook creates a Job for the current version of sources if not exist, and waits for its completion. Jobs are
+// named after phase and index of the hook by default, so hooks of a rule do not share Jobs. A failed Job of the
+// current sources is only deleted and created again if retry is set, nothing is done if it completed.
+func (t *DefaultTrigger) runHook(ctx context.Context, rule *appv1alpha1.TriggerRule, phase string, index int, hook *appv1alpha1.Hook, retry bool) (*appv1alpha1.ActionStatus, error) {
+	src := &jobSource{
+		namespace: rule.Namespace,
+		name:      fmt.Sprintf("%s-%s-%d", rule.Name, phase, index),
+		policy:    appv1alpha1.JobNamingHashSuffix,
+		template:  *hook.Template.DeepCopy(),
+	}
+	if hook.Name != "" {
+		src.name = hook.Name
+	}
+	selector := map[string]string{
+		RuleUIDLabel: string(rule.UID),
+		HookLabel:    fmt.Sprintf("%s-%d", phase, index),
+	}
+
+	job, created, err := t.ensureJob(ctx, rule, src, selector, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		cond, finished := jobFinished(job)
+		switch {
+		case finished && cond.Type == batchv1.JobFailed && retry:
+			if job, err = t.rerunJob(ctx, job, selector); err != nil {
+				return nil, err
+			}
+		case finished && cond.Type == batchv1.JobComplete:
+			return nil, nil
+		}
+	}
+	return t.waitForJobResult(ctx, job, hook.TimeoutSeconds)
+}
@@ -0,0 +1,436 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// RuleUIDLabel is added to objects created by kube-trigger, value is UID of the TriggerRule.
+	RuleUIDLabel = "trigger.app.example.com/rule-uid"
+	// ActionIndexLabel is added to objects created by kube-trigger, value is index of the action.
+	ActionIndexLabel = "trigger.app.example.com/action-index"
+
+	defaultJobHistoryLimit  = 3
+	defaultWaitTimeout      = 600 * time.Second
+	jobPollInterval         = 2 * time.Second
+	maxJobNamePrefixLength  = 52
+	jobDeletionPollTimeout  = 30 * time.Second
+	jobDeletionPollInterval = time.Second
+)
+
+// jobSource is the resolved source of Jobs created by RunJob.
+type jobSource struct {
+	namespace string
+	name      string
+	policy    appv1alpha1.JobNamingPolicy
+	template  batchv1beta1.JobTemplateSpec
+	owner     *metav1.OwnerReference
+}
+
+func (t *DefaultTrigger) runJob(ctx context.Context, rule *appv1alpha1.TriggerRule, index int, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	src, err := t.resolveJobSource(rule, index, action.RunJob)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := actionSelector(rule, index)
+	job, created, err := t.ensureJob(ctx, rule, src, selector, action.RunJob.HistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		// The Job of the current sources exists. The action only runs again for the same sources when it is
+		// retried, in which case a failed Job is run again, and a running Job is waited for. Results of Jobs are
+		// not part of the action unless it waits for completion.
+		cond, finished := jobFinished(job)
+		switch {
+		case finished && cond.Type == batchv1.JobFailed && action.RunJob.WaitForCompletion:
+			if job, err = t.rerunJob(ctx, job, selector); err != nil {
+				return nil, err
+			}
+		case !finished && action.RunJob.WaitForCompletion:
+			return t.waitForJobResult(ctx, job, action.RunJob.TimeoutSeconds)
+		default:
+			return nil, nil
+		}
+	}
+
+	if !action.RunJob.WaitForCompletion {
+		return &appv1alpha1.ActionStatus{
+			Phase:     appv1alpha1.ActionSucceeded,
+			Reason:    "JobCreated",
+			ObjectRef: jobReference(job),
+		}, nil
+	}
+	return t.waitForJobResult(ctx, job, action.RunJob.TimeoutSeconds)
+}
+
+// waitForJobResult waits for job to finish, the final condition is returned as status.
+// An error is returned if the Job failed or the wait timed out.
+func (t *DefaultTrigger) waitForJobResult(ctx context.Context, job *batchv1.Job, timeoutSeconds *int64) (*appv1alpha1.ActionStatus, error) {
+	status := &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		ObjectRef: jobReference(job),
+	}
+	cond, err := t.waitForJob(ctx, job.Namespace, job.Name, waitTimeout(timeoutSeconds))
+	if err != nil {
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "WaitFailed"
+		status.Message = err.Error()
+		return status, err
+	}
+	status.Message = cond.Message
+	if cond.Type == batchv1.JobFailed {
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "JobFailed"
+		return status, fmt.Errorf("job %s/%s failed: %v", job.Namespace, job.Name, cond.Message)
+	}
+	status.Reason = "JobComplete"
+	return status, nil
+}
+
+// ensureJob creates a new Job from src if sources changed since the last Job matching selector was created.
+// The last Job is returned if sources are not changed.
+func (t *DefaultTrigger) ensureJob(ctx context.Context, rule *appv1alpha1.TriggerRule, src *jobSource, selector map[string]string, historyLimit *int32) (*batchv1.Job, bool, error) {
+	annotationKey := GetRecordKey(rule.Name, rule.Namespace)
+	last, err := t.lastJob(src, selector)
+	if err != nil {
+		return nil, false, err
+	}
+	var annotations map[string]string
+	if last != nil {
+		annotations = last.Annotations
+	}
+	rec, err := t.generateNewRecord(rule, annotations, annotationKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("err generate record: %w", err)
+	}
+	if rec == nil {
+		return last, false, nil
+	}
+
+	job, err := newJob(src, rec, annotationKey, selector)
+	if err != nil {
+		return nil, false, err
+	}
+	if src.policy == appv1alpha1.JobNamingRecreate && last != nil {
+		if err := t.deleteJob(ctx, job.Namespace, job.Name); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := t.createJob(job, selector); err != nil {
+		return nil, false, err
+	}
+	if src.policy == appv1alpha1.JobNamingHashSuffix {
+		t.cleanupJobs(ctx, job, selector, historyLimit)
+	}
+	return job, true, nil
+}
+
+// resolveJobSource finds template of Jobs from JobRef, CronJobRef or Template. Jobs from Template are named after
+// the rule and index of the action, so actions of the same rule do not share Jobs.
+func (t *DefaultTrigger) resolveJobSource(rule *appv1alpha1.TriggerRule, index int, spec *appv1alpha1.ActionRunJob) (*jobSource, error) {
+	src := &jobSource{policy: spec.NamingPolicy}
+	switch {
+	case spec.JobRef != nil:
+		ref := spec.JobRef
+		job, err := t.client.BatchV1().Jobs(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("err get job: %w", err)
+		}
+		src.namespace, src.name = job.Namespace, job.Name
+		src.template = jobTemplateFromJob(job)
+		if src.policy == "" {
+			src.policy = appv1alpha1.JobNamingRecreate
+		}
+	case spec.CronJobRef != nil:
+		ref := spec.CronJobRef
+		cj, err := t.client.BatchV1beta1().CronJobs(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("err get cronjob: %w", err)
+		}
+		src.namespace, src.name = cj.Namespace, cj.Name
+		src.template = *cj.Spec.JobTemplate.DeepCopy()
+		// Same as "kubectl create job --from=cronjob/<name>"
+		if src.template.Annotations == nil {
+			src.template.Annotations = map[string]string{}
+		}
+		src.template.Annotations["cronjob.kubernetes.io/instantiate"] = "manual"
+		controller := true
+		src.owner = &metav1.OwnerReference{
+			APIVersion: batchv1beta1.SchemeGroupVersion.String(),
+			Kind:       "CronJob",
+			Name:       cj.Name,
+			UID:        cj.UID,
+			Controller: &controller,
+		}
+	case spec.Template != nil:
+		src.namespace, src.name = rule.Namespace, fmt.Sprintf("%s-%d", rule.Name, index)
+		src.template = *spec.Template.DeepCopy()
+	default:
+		return nil, fmt.Errorf("one of jobRef, cronJobRef and template must be set")
+	}
+
+	if spec.Name != "" {
+		src.name = spec.Name
+	}
+	if src.policy == "" {
+		src.policy = appv1alpha1.JobNamingHashSuffix
+	}
+	if src.policy != appv1alpha1.JobNamingRecreate && src.policy != appv1alpha1.JobNamingHashSuffix {
+		return nil, fmt.Errorf("unsupported naming policy %v", src.policy)
+	}
+	return src, nil
+}
+
+// jobTemplateFromJob copies spec of job, fields generated by job controller are removed.
+func jobTemplateFromJob(job *batchv1.Job) batchv1beta1.JobTemplateSpec {
+	tmpl := batchv1beta1.JobTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      job.Labels,
+			Annotations: job.Annotations,
+		},
+		Spec: *job.Spec.DeepCopy(),
+	}
+	if tmpl.Spec.ManualSelector == nil || !*tmpl.Spec.ManualSelector {
+		tmpl.Spec.Selector = nil
+		delete(tmpl.Spec.Template.Labels, "controller-uid")
+		delete(tmpl.Spec.Template.Labels, "job-name")
+		delete(tmpl.Labels, "controller-uid")
+		delete(tmpl.Labels, "job-name")
+	}
+	return tmpl
+}
+
+// actionSelector returns labels of objects created by the action.
+func actionSelector(rule *appv1alpha1.TriggerRule, index int) map[string]string {
+	return map[string]string{
+		RuleUIDLabel:     string(rule.UID),
+		ActionIndexLabel: strconv.Itoa(index),
+	}
+}
+
+// lastJob returns the Job created by the last execution, nil if not exist.
+func (t *DefaultTrigger) lastJob(src *jobSource, selector map[string]string) (*batchv1.Job, error) {
+	if src.policy == appv1alpha1.JobNamingRecreate {
+		job, err := t.client.BatchV1().Jobs(src.namespace).Get(src.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("err get job: %w", err)
+		}
+		return job, nil
+	}
+
+	jobs, err := t.listJobs(src.namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[len(jobs)-1], nil
+}
+
+// listJobs returns Jobs matching selector sorted by creation time.
+func (t *DefaultTrigger) listJobs(namespace string, selector map[string]string) ([]batchv1.Job, error) {
+	list, err := t.client.BatchV1().Jobs(namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("err list jobs: %w", err)
+	}
+	jobs := list.Items
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreationTimestamp.Before(&jobs[j].CreationTimestamp)
+	})
+	return jobs, nil
+}
+
+func newJob(src *jobSource, rec *Record, key string, selector map[string]string) (*batchv1.Job, error) {
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("err encode %#v: %w", rec, err)
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: *src.template.ObjectMeta.DeepCopy(),
+		Spec:       *src.template.Spec.DeepCopy(),
+	}
+	job.Namespace = src.namespace
+	job.Name = src.name
+	if src.policy == appv1alpha1.JobNamingHashSuffix {
+		prefix := src.name
+		if len(prefix) > maxJobNamePrefixLength {
+			prefix = prefix[:maxJobNamePrefixLength]
+		}
+		job.Name = prefix + "-" + rec.hash()
+	}
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	for k, v := range selector {
+		job.Labels[k] = v
+	}
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[key] = string(val)
+	if src.owner != nil {
+		job.OwnerReferences = []metav1.OwnerReference{*src.owner}
+	}
+	return job, nil
+}
+
+// createJob creates job, an existing Job of the same name is reused only if it is created by the same action, i.e.
+// it matches selector.
+func (t *DefaultTrigger) createJob(job *batchv1.Job, selector map[string]string) error {
+	t.logger.Info("Create job", "namespace", job.Namespace, "name", job.Name)
+	_, err := t.client.BatchV1().Jobs(job.Namespace).Create(job)
+	if errors.IsAlreadyExists(err) {
+		existing, err := t.client.BatchV1().Jobs(job.Namespace).Get(job.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("err get job: %w", err)
+		}
+		if !labels.SelectorFromSet(selector).Matches(labels.Set(existing.Labels)) {
+			return fmt.Errorf("job %s/%s already exists and is not created by this action", job.Namespace, job.Name)
+		}
+		// Job of the same sources has been created.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("err create job: %w", err)
+	}
+	return nil
+}
+
+// rerunJob deletes the finished job, and creates it again with the same name, labels and record.
+func (t *DefaultTrigger) rerunJob(ctx context.Context, job *batchv1.Job, selector map[string]string) (*batchv1.Job, error) {
+	tmpl := jobTemplateFromJob(job)
+	rerun := &batchv1.Job{ObjectMeta: tmpl.ObjectMeta, Spec: tmpl.Spec}
+	rerun.Namespace, rerun.Name, rerun.OwnerReferences = job.Namespace, job.Name, job.OwnerReferences
+	if err := t.deleteJob(ctx, job.Namespace, job.Name); err != nil {
+		return nil, err
+	}
+	if err := t.createJob(rerun, selector); err != nil {
+		return nil, err
+	}
+	return rerun, nil
+}
+
+// deleteJob deletes the Job and waits until it is removed, pods of the Job are deleted in background. The wait is
+// canceled with ctx.
+func (t *DefaultTrigger) deleteJob(ctx context.Context, namespace, name string) error {
+	t.logger.Info("Delete job", "namespace", namespace, "name", name)
+	propagation := metav1.DeletePropagationBackground
+	err := t.client.BatchV1().Jobs(namespace).Delete(name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("err delete job: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, jobDeletionPollTimeout)
+	defer cancel()
+	err = wait.PollImmediateUntil(jobDeletionPollInterval, func() (bool, error) {
+		_, err := t.client.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("err wait for deletion of job %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// cleanupJobs deletes finished Jobs exceeding history limit, errors are only logged.
+func (t *DefaultTrigger) cleanupJobs(ctx context.Context, current *batchv1.Job, selector map[string]string, limit *int32) {
+	historyLimit := defaultJobHistoryLimit
+	if limit != nil {
+		historyLimit = int(*limit)
+	}
+
+	jobs, err := t.listJobs(current.Namespace, selector)
+	if err != nil {
+		t.logger.Error(err, "Cleanup jobs failed")
+		return
+	}
+	var finished []batchv1.Job
+	for _, job := range jobs {
+		if job.Name == current.Name {
+			continue
+		}
+		if _, ok := jobFinished(&job); ok {
+			finished = append(finished, job)
+		}
+	}
+	for i := 0; i < len(finished)-historyLimit; i++ {
+		if err := t.deleteJob(ctx, finished[i].Namespace, finished[i].Name); err != nil {
+			t.logger.Error(err, "Cleanup jobs failed")
+		}
+	}
+}
+
+// waitForJob waits until the Job finished, the final condition is returned.
+func (t *DefaultTrigger) waitForJob(ctx context.Context, namespace, name string, timeout time.Duration) (*batchv1.JobCondition, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cond *batchv1.JobCondition
+	err := wait.PollImmediateUntil(jobPollInterval, func() (bool, error) {
+		job, err := t.client.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		c, ok := jobFinished(job)
+		if ok {
+			cond = c
+		}
+		return ok, nil
+	}, ctx.Done())
+	if err != nil {
+		return nil, fmt.Errorf("err wait for job %s/%s: %w", namespace, name, err)
+	}
+	return cond, nil
+}
+
+// jobFinished returns the Complete or Failed condition of job.
+func jobFinished(job *batchv1.Job) (*batchv1.JobCondition, bool) {
+	for i := range job.Status.Conditions {
+		c := &job.Status.Conditions[i]
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func waitTimeout(seconds *int64) time.Duration {
+	if seconds == nil || *seconds <= 0 {
+		return defaultWaitTimeout
+	}
+	return time.Duration(*seconds) * time.Second
+}
+
+func jobReference(job *batchv1.Job) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: batchv1.SchemeGroupVersion.String(),
+		Kind:       "Job",
+		Namespace:  job.Namespace,
+		Name:       job.Name,
+	}
+}
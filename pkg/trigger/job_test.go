@@ -0,0 +1,237 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+func TestNewJob(t *testing.T) {
+	manual := false
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "migrate",
+			Namespace: "foo-ns",
+			Labels:    map[string]string{"app": "foo", "job-name": "migrate"},
+		},
+		Spec: batchv1.JobSpec{
+			ManualSelector: &manual,
+			Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"controller-uid": "123"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"controller-uid": "123", "job-name": "migrate"},
+				},
+			},
+		},
+	}
+	rec := &Record{
+		LastUpdateTime: 1560210953900081130,
+		Sources: []Source{
+			{Name: "foo", Namespace: "foo-ns", Kind: "ConfigMap", ResourceVersion: "1"},
+		},
+	}
+	selector := map[string]string{RuleUIDLabel: "uid", ActionIndexLabel: "0"}
+	key := GetRecordKey("foo", "foo-ns")
+
+	src := &jobSource{
+		namespace: job.Namespace,
+		name:      job.Name,
+		policy:    appv1alpha1.JobNamingHashSuffix,
+		template:  jobTemplateFromJob(job),
+	}
+	newJob1, err := newJob(src, rec, key, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newJob1.Spec.Selector != nil {
+		t.Error("Expect selector to be removed")
+	}
+	if _, ok := newJob1.Spec.Template.Labels["controller-uid"]; ok {
+		t.Error("Expect controller-uid label to be removed")
+	}
+	if !strings.HasPrefix(newJob1.Name, "migrate-") {
+		t.Errorf("Unexpected name %v", newJob1.Name)
+	}
+	if newJob1.Labels[RuleUIDLabel] != "uid" || newJob1.Annotations[key] == "" {
+		t.Error("Expect labels and record to be set")
+	}
+
+	// Name only depends on versions of sources.
+	rec.LastUpdateTime++
+	newJob2, err := newJob(src, rec, key, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newJob1.Name != newJob2.Name {
+		t.Errorf("Expect same name, got %v and %v", newJob1.Name, newJob2.Name)
+	}
+	rec.Sources[0].ResourceVersion = "2"
+	newJob3, err := newJob(src, rec, key, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newJob1.Name == newJob3.Name {
+		t.Errorf("Expect different names, got %v", newJob3.Name)
+	}
+}
+
+func TestCreateJobOwnership(t *testing.T) {
+	existing := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:      "foo-0-abc",
+		Namespace: "foo-ns",
+		Labels:    map[string]string{RuleUIDLabel: "uid", ActionIndexLabel: "0"},
+	}}
+	tr := &DefaultTrigger{client: fake.NewSimpleClientset(existing), logger: logf.NullLogger{}}
+
+	job := existing.DeepCopy()
+	if err := tr.createJob(job, map[string]string{RuleUIDLabel: "uid", ActionIndexLabel: "0"}); err != nil {
+		t.Errorf("expect job of the same action reused, got %v", err)
+	}
+	if err := tr.createJob(job, map[string]string{RuleUIDLabel: "uid", ActionIndexLabel: "1"}); err == nil {
+		t.Error("expect error for job created by another action")
+	}
+}
+
+func TestResolveJobSourceName(t *testing.T) {
+	tr := &DefaultTrigger{}
+	rule := &appv1alpha1.TriggerRule{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"}}
+	spec := &appv1alpha1.ActionRunJob{Template: &batchv1beta1.JobTemplateSpec{}}
+	a, err := tr.resolveJobSource(rule, 0, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := tr.resolveJobSource(rule, 1, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.name == b.name {
+		t.Errorf("expect different names for different actions, got %v", a.name)
+	}
+}
+
+func TestRunJobRetryRerunsFailedJob(t *testing.T) {
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns", UID: "uid"},
+		Spec: appv1alpha1.TriggerRuleSpec{Sources: []appv1alpha1.Source{
+			{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}},
+		}},
+	}
+	rec, _ := json.Marshal(&Record{Sources: []Source{{Name: "foo", Namespace: "foo-ns", Kind: "ConfigMap", ResourceVersion: "1"}}})
+	failed := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "foo-0-abc",
+			Namespace:   "foo-ns",
+			Labels:      actionSelector(rule, 0),
+			Annotations: map[string]string{GetRecordKey("foo", "foo-ns"): string(rec)},
+		},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}},
+	}
+	tr := &DefaultTrigger{client: fake.NewSimpleClientset(failed), logger: logf.NullLogger{}}
+	timeout := int64(1)
+	action := &appv1alpha1.Action{RunJob: &appv1alpha1.ActionRunJob{
+		Template:          &batchv1beta1.JobTemplateSpec{},
+		WaitForCompletion: true,
+		TimeoutSeconds:    &timeout,
+	}}
+
+	status, err := tr.runJob(context.Background(), rule, 0, action)
+	if status == nil || status.Reason != "WaitFailed" || err == nil {
+		t.Fatalf("expect the rerun Job waited for, got %#v, %v", status, err)
+	}
+	if retryable(err) {
+		t.Errorf("expect timeout waiting for the Job not retryable, got %v", err)
+	}
+	job, err := tr.client.BatchV1().Jobs("foo-ns").Get("foo-0-abc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, finished := jobFinished(job); finished {
+		t.Error("expect the failed Job to be run again")
+	}
+}
+
+func TestRunHooksRerunFailedJob(t *testing.T) {
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns", UID: "uid"},
+		Spec: appv1alpha1.TriggerRuleSpec{Sources: []appv1alpha1.Source{
+			{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}},
+		}},
+	}
+	rec, _ := json.Marshal(&Record{Sources: []Source{{Name: "foo", Namespace: "foo-ns", Kind: "ConfigMap", ResourceVersion: "1"}}})
+	failed := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "foo-pre-0-abc",
+			Namespace:   "foo-ns",
+			Labels:      map[string]string{RuleUIDLabel: "uid", HookLabel: "pre-0"},
+			Annotations: map[string]string{GetRecordKey("foo", "foo-ns"): string(rec)},
+		},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}},
+	}
+	tr := New(nil, fake.NewSimpleClientset(failed), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), nil, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+	timeout := int64(1)
+	hooks := []appv1alpha1.Hook{{Template: batchv1beta1.JobTemplateSpec{}, TimeoutSeconds: &timeout}}
+
+	// The failed Job is not run again without a retry.
+	if _, err := tr.runHooks(context.Background(), rule, hookPre, hooks, &appv1alpha1.TriggerRuleStatus{}, false); err == nil {
+		t.Fatal("expect the failed hook to fail the run")
+	}
+	if job, _ := tr.client.BatchV1().Jobs("foo-ns").Get("foo-pre-0-abc", metav1.GetOptions{}); job == nil || len(job.Status.Conditions) == 0 {
+		t.Fatal("expect the failed Job kept")
+	}
+
+	// Once the backoff of the failed attempt passed, the Job is run again.
+	latest := &appv1alpha1.TriggerRuleStatus{PreActions: []appv1alpha1.ActionStatus{
+		{Index: 0, Phase: appv1alpha1.ActionFailed, Attempts: 1, SourcesHash: sourcesHash(rule)},
+	}}
+	delay, err := tr.runHooks(context.Background(), rule, hookPre, hooks, latest, false)
+	if err == nil || delay != backoff(nil, 2) {
+		t.Fatalf("expect the rerun Job waited for and backed off, got %v, %v", delay, err)
+	}
+	job, err := tr.client.BatchV1().Jobs("foo-ns").Get("foo-pre-0-abc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, finished := jobFinished(job); finished {
+		t.Error("expect the failed Job to be run again")
+	}
+
+	// Exhausted hooks are not run until a retry is requested.
+	latest.PreActions[0].Phase = appv1alpha1.ActionExhausted
+	if delay, err := tr.runHooks(context.Background(), rule, hookPre, hooks, latest, false); err == nil || delay != 0 {
+		t.Errorf("expect exhausted hook not run, got %v, %v", delay, err)
+	}
+}
+
+func TestDeleteJobCanceled(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"}}
+	client := fake.NewSimpleClientset(job)
+	// The Job is kept, e.g. by a finalizer.
+	client.PrependReactor("delete", "jobs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	tr := &DefaultTrigger{client: client, logger: logf.NullLogger{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := tr.deleteJob(ctx, "foo-ns", "foo"); err == nil {
+		t.Fatal("expect the wait for deletion canceled")
+	}
+	if time.Since(start) > jobDeletionPollInterval {
+		t.Errorf("expect the wait to return once canceled, took %v", time.Since(start))
+	}
+}
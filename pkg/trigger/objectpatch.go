@@ -0,0 +1,70 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+var patchTypes = map[appv1alpha1.PatchType]types.PatchType{
+	appv1alpha1.JSONPatchType:           types.JSONPatchType,
+	appv1alpha1.MergePatchType:          types.MergePatchType,
+	appv1alpha1.StrategicMergePatchType: types.StrategicMergePatchType,
+}
+
+// patchObject renders the patch template and applies it to the object. Like scale, record is kept in annotations
+// of the object, so the patch is applied once for each version of sources and of the patch.
+func (t *DefaultTrigger) patchObject(ctx context.Context, rule *appv1alpha1.TriggerRule, index int, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.Patch
+	ref := &spec.ObjectRef
+
+	pt := types.MergePatchType
+	if spec.Type != "" {
+		var ok bool
+		if pt, ok = patchTypes[spec.Type]; !ok {
+			return nil, fmt.Errorf("unsupported patch type %v", spec.Type)
+		}
+	}
+
+	// Edits of the patch are applied without changes of sources.
+	patchSpec, err := specHash([]string{string(pt), spec.Patch})
+	if err != nil {
+		return nil, err
+	}
+	ri, rec, err := t.recordOnObject(rule, ref, patchSpec)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	data, err := t.templateData(rule, rec)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := renderTemplate(fmt.Sprintf("actions[%d].patch", index), spec.Patch, data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := yaml.ToJSON(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("err decode rendered patch: %w", err)
+	}
+
+	t.logger.Info("Patch object", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name, "patch", string(body))
+	if _, err := ri.Patch(ref.Name, pt, body, metav1.UpdateOptions{}); err != nil {
+		return nil, fmt.Errorf("err patch %v: %w", ref.Kind, err)
+	}
+
+	if err := t.patchRecord(ri, rule, ref.Name, rec, nil); err != nil {
+		return nil, err
+	}
+
+	return &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    "Patched",
+		ObjectRef: ref.DeepCopy(),
+	}, nil
+}
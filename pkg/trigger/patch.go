@@ -3,6 +3,8 @@ package trigger
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"sort"
 	"strings"
 )
 
@@ -10,6 +12,31 @@ import (
 type Record struct {
 	LastUpdateTime int64    `json:"lastUpdateTime,omitempty"`
 	Sources        []Source `json:"sources,omitempty"`
+	// Spec is the hash of templates of the action, so the action is done again when they are edited.
+	Spec string `json:"spec,omitempty"`
+}
+
+// hash returns a short hash of versions of sources and the spec, LastUpdateTime is not included.
+func (r *Record) hash() string {
+	h := fnv.New32a()
+	for _, src := range r.Sources {
+		fmt.Fprintf(h, "%s/%s/%s/%s;", src.Kind, src.Namespace, src.Name, src.ResourceVersion)
+	}
+	if r.Spec != "" {
+		fmt.Fprintf(h, "spec=%s;", r.Spec)
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// specHash returns a short hash of v encoded in JSON, e.g. templates of an action.
+func specHash(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("err encode spec: %w", err)
+	}
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%08x", h.Sum32()), nil
 }
 
 type Source struct {
@@ -33,32 +60,52 @@ func escapeJSONPointerValue(in string) string {
 	return strings.Replace(step, "/", "~1", -1)
 }
 
-// FIXME: annotations may not exist, in this case we should patch to add entire annotations field.
-func generatePatch(rec *Record, key string, empty bool) ([]byte, error) {
-	val, err := json.Marshal(rec)
-	if err != nil {
-		return nil, fmt.Errorf("err encode %#v: %v", rec, err)
+// annotationsPointer returns JSON Pointer of annotations of pod template located at templatePath.
+func annotationsPointer(templatePath []string) string {
+	return jsonPointer(templatePath) + "/metadata/annotations"
+}
+
+// generatePatch generates a JSON patch to set record to annotations of pod template located at templatePath.
+// If empty is true, the entire annotations field will be added.
+func generatePatch(rec *Record, key string, templatePath []string, empty bool) ([]byte, error) {
+	return generateRecordsPatch(map[string]*Record{key: rec}, templatePath, empty)
+}
+
+// generateRecordsPatch generates a JSON patch to set records keyed by annotation keys to annotations of pod
+// template located at templatePath, so records of several rules are applied in one rollout.
+// If empty is true, the entire annotations field will be added.
+func generateRecordsPatch(records map[string]*Record, templatePath []string, empty bool) ([]byte, error) {
+	var keys []string
+	values := map[string]string{}
+	for key, rec := range records {
+		val, err := json.Marshal(rec)
+		if err != nil {
+			return nil, fmt.Errorf("err encode %#v: %w", rec, err)
+		}
+		keys = append(keys, key)
+		values[key] = string(val)
 	}
+	sort.Strings(keys)
 
-	var pt []byte
+	var ops []interface{}
 	if empty {
-		pt, err = json.Marshal([]interface{}{
-			map[string]interface{}{
-				"op":   "add",
-				"path": "/spec/template/metadata/annotations",
-				"value": map[string]string{
-					key: string(val),
-				},
-			},
+		ops = append(ops, map[string]interface{}{
+			"op":    "add",
+			"path":  annotationsPointer(templatePath),
+			"value": values,
 		})
 	} else {
-		pt, err = json.Marshal([]interface{}{
-			map[string]interface{}{
+		for _, key := range keys {
+			ops = append(ops, map[string]interface{}{
 				"op":    "add",
-				"path":  fmt.Sprintf("/spec/template/metadata/annotations/%s", escapeJSONPointerValue(key)),
-				"value": string(val),
-			},
-		})
+				"path":  annotationsPointer(templatePath) + "/" + escapeJSONPointerValue(key),
+				"value": values[key],
+			})
+		}
+	}
+	pt, err := json.Marshal(ops)
+	if err != nil {
+		return nil, fmt.Errorf("err encode patch: %w", err)
 	}
 
 	return pt, nil
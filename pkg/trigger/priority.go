@@ -0,0 +1,81 @@
+package trigger
+
+import (
+	"container/heap"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// readyItem is a key ready to be processed.
+type readyItem struct {
+	key      types.NamespacedName
+	priority int32
+	// seq keeps keys with the same priority in order of arrival.
+	seq uint64
+}
+
+type readyHeap []readyItem
+
+func (h readyHeap) Len() int { return len(h) }
+func (h readyHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h readyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *readyHeap) Push(x interface{}) { *h = append(*h, x.(readyItem)) }
+func (h *readyHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// readyQueue orders keys taken from the workqueue by priority of their rules, so keys with higher priority are
+// processed first when workers are saturated.
+type readyQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  readyHeap
+	seq    uint64
+	closed bool
+}
+
+func newReadyQueue() *readyQueue {
+	q := &readyQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a key with priority.
+func (q *readyQueue) push(key types.NamespacedName, priority int32) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	heap.Push(&q.items, readyItem{key: key, priority: priority, seq: q.seq})
+	q.cond.Signal()
+}
+
+// pop blocks until a key is available, and returns the key with the highest priority. It returns false if the
+// queue is closed and empty.
+func (q *readyQueue) pop() (types.NamespacedName, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return types.NamespacedName{}, false
+	}
+	return heap.Pop(&q.items).(readyItem).key, true
+}
+
+// close wakes up all workers waiting in pop.
+func (q *readyQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
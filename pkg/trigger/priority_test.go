@@ -0,0 +1,36 @@
+package trigger
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReadyQueue(t *testing.T) {
+	q := newReadyQueue()
+	for _, item := range []struct {
+		name     string
+		priority int32
+	}{{"routine-1", 0}, {"cert", 100}, {"routine-2", 0}, {"batch", -10}, {"credential", 100}} {
+		q.push(types.NamespacedName{Name: item.name}, item.priority)
+	}
+	q.close()
+
+	var got []string
+	for {
+		key, ok := q.pop()
+		if !ok {
+			break
+		}
+		got = append(got, key.Name)
+	}
+	expect := []string{"cert", "credential", "routine-1", "routine-2", "batch"}
+	if len(got) != len(expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Fatalf("expect %v, got %v", expect, got)
+		}
+	}
+}
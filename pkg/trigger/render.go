@@ -0,0 +1,153 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// render renders templates over sources and writes the results to the target ConfigMap or Secret. The record is
+// kept in annotations of the target, and only renewed when sources changed, so templates using the record render
+// the same data until then.
+func (t *DefaultTrigger) render(ctx context.Context, rule *appv1alpha1.TriggerRule, index int, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.Render
+	target := spec.Target.DeepCopy()
+	if target.Namespace == "" {
+		target.Namespace = rule.Namespace
+	}
+	target.APIVersion = "v1"
+	annotationKey := GetRecordKey(rule.Name, rule.Namespace)
+
+	var meta *metav1.ObjectMeta
+	current := map[string]string{}
+	switch target.Kind {
+	case "ConfigMap":
+		cm, err := t.client.CoreV1().ConfigMaps(target.Namespace).Get(target.Name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("err get configmap: %w", err)
+		}
+		if err == nil {
+			meta, current = &cm.ObjectMeta, cm.Data
+		}
+	case "Secret":
+		sc, err := t.client.CoreV1().Secrets(target.Namespace).Get(target.Name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("err get secret: %w", err)
+		}
+		if err == nil {
+			meta = &sc.ObjectMeta
+			for k, v := range sc.Data {
+				current[k] = string(v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported target kind %v", target.Kind)
+	}
+	if meta != nil && meta.Labels[RuleUIDLabel] != string(rule.UID) {
+		return nil, fmt.Errorf("%v %s/%s already exists and is not managed by the rule", target.Kind, target.Namespace, target.Name)
+	}
+
+	var annotations map[string]string
+	if meta != nil {
+		annotations = meta.Annotations
+	}
+	rec, err := t.generateNewRecord(rule, annotations, annotationKey)
+	if err != nil {
+		return nil, fmt.Errorf("err generate record: %w", err)
+	}
+	if rec == nil {
+		if rec, err = decodeRecordFromAnnotaion(annotations, annotationKey); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := t.templateData(rule, rec)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for k := range spec.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rendered := map[string]string{}
+	for _, k := range keys {
+		out, err := renderTemplate(fmt.Sprintf("actions[%d].data.%s", index, k), spec.Data[k], data)
+		if err != nil {
+			return nil, err
+		}
+		rendered[k] = string(out)
+	}
+	if meta != nil && reflect.DeepEqual(current, rendered) {
+		return nil, nil
+	}
+
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("err encode %#v: %w", rec, err)
+	}
+	if meta == nil {
+		meta = &metav1.ObjectMeta{Name: target.Name, Namespace: target.Namespace}
+	}
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	for k, v := range actionSelector(rule, index) {
+		meta.Labels[k] = v
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[annotationKey] = string(val)
+
+	t.logger.Info("Write rendered data", "kind", target.Kind, "namespace", target.Namespace, "name", target.Name)
+	if err := t.writeRendered(target.Kind, meta, rendered); err != nil {
+		return &appv1alpha1.ActionStatus{
+			Phase:     appv1alpha1.ActionFailed,
+			Reason:    "WriteFailed",
+			Message:   err.Error(),
+			ObjectRef: target,
+		}, err
+	}
+	return &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    "Rendered",
+		Message:   fmt.Sprintf("%d keys rendered", len(rendered)),
+		ObjectRef: target,
+	}, nil
+}
+
+// writeRendered creates the ConfigMap or Secret, or updates it if resourceVersion is set in meta.
+func (t *DefaultTrigger) writeRendered(kind string, meta *metav1.ObjectMeta, data map[string]string) error {
+	var err error
+	switch kind {
+	case "ConfigMap":
+		cm := &corev1.ConfigMap{ObjectMeta: *meta, Data: data}
+		if meta.ResourceVersion == "" {
+			_, err = t.client.CoreV1().ConfigMaps(meta.Namespace).Create(cm)
+		} else {
+			_, err = t.client.CoreV1().ConfigMaps(meta.Namespace).Update(cm)
+		}
+	case "Secret":
+		sc := &corev1.Secret{ObjectMeta: *meta, Data: map[string][]byte{}}
+		for k, v := range data {
+			sc.Data[k] = []byte(v)
+		}
+		if meta.ResourceVersion == "" {
+			_, err = t.client.CoreV1().Secrets(meta.Namespace).Create(sc)
+		} else {
+			_, err = t.client.CoreV1().Secrets(meta.Namespace).Update(sc)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("err write %v %s/%s: %w", kind, meta.Namespace, meta.Name, err)
+	}
+	return nil
+}
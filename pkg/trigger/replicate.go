@@ -0,0 +1,270 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// replicate keeps copies of sources in namespaces matching the selector. Each copy carries the record of sources,
+// so it is only updated when sources changed.
+func (t *DefaultTrigger) replicate(ctx context.Context, rule *appv1alpha1.TriggerRule, index int, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.Replicate
+	if spec.NamespaceSelector == nil {
+		return nil, fmt.Errorf("namespaceSelector is required")
+	}
+	nsSelector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("err parse namespaceSelector: %w", err)
+	}
+	nsList, err := t.client.CoreV1().Namespaces().List(metav1.ListOptions{LabelSelector: nsSelector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("err list namespaces: %w", err)
+	}
+	var namespaces []string
+	for _, ns := range nsList.Items {
+		if ns.Status.Phase != corev1.NamespaceTerminating {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	sources, err := replicatedSources(rule, spec.Sources)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := actionSelector(rule, index)
+	want := map[corev1.ObjectReference]bool{}
+	var objects []corev1.ObjectReference
+	replicated := 0
+	for _, src := range sources {
+		src := src
+		ri, _, err := t.resourceFor(&src)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := ri.Get(src.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("err get %v %s/%s: %w", src.Kind, src.Namespace, src.Name, err)
+		}
+		for _, ns := range namespaces {
+			if ns == src.Namespace {
+				continue
+			}
+			ref := corev1.ObjectReference{APIVersion: "v1", Kind: src.Kind, Namespace: ns, Name: src.Name}
+			want[ref] = true
+			objects = append(objects, ref)
+			changed, err := t.replicateTo(rule, selector, obj, &ref)
+			if err != nil {
+				return &appv1alpha1.ActionStatus{
+					Phase:   appv1alpha1.ActionFailed,
+					Reason:  "ReplicateFailed",
+					Message: err.Error(),
+					Objects: objects,
+				}, err
+			}
+			if changed {
+				replicated++
+			}
+		}
+	}
+
+	deleted, err := t.deleteReplicas(selector, want)
+	if err != nil {
+		return &appv1alpha1.ActionStatus{
+			Phase:   appv1alpha1.ActionFailed,
+			Reason:  "DeleteFailed",
+			Message: err.Error(),
+			Objects: objects,
+		}, err
+	}
+
+	updated := 0
+	var deferred *deferredError
+	for i := range spec.Rollouts {
+		for _, ns := range namespaces {
+			ok, err := t.rolloutInNamespace(ctx, rule, &spec.Rollouts[i], ns)
+			if d, rolling := isDeferred(err); rolling {
+				deferred = earlier(deferred, d)
+				err = nil
+			}
+			if err != nil {
+				return &appv1alpha1.ActionStatus{
+					Phase:   appv1alpha1.ActionFailed,
+					Reason:  "RolloutFailed",
+					Message: err.Error(),
+					Objects: objects,
+				}, err
+			}
+			if ok {
+				updated++
+			}
+		}
+	}
+
+	if replicated == 0 && deleted == 0 && updated == 0 {
+		return nil, nil
+	}
+	status := &appv1alpha1.ActionStatus{
+		Phase:   appv1alpha1.ActionSucceeded,
+		Reason:  "Replicated",
+		Message: fmt.Sprintf("%d copies created or updated, %d copies deleted, %d workloads updated", replicated, deleted, updated),
+		Objects: objects,
+	}
+	if deferred != nil {
+		// Workloads being rolled out are checked again when the earliest of them is due.
+		status.Phase = appv1alpha1.ActionRunning
+		return status, deferred
+	}
+	return status, nil
+}
+
+// replicatedSources returns sources of rule matching names, all sources are returned if names is empty.
+func replicatedSources(rule *appv1alpha1.TriggerRule, names []string) ([]corev1.ObjectReference, error) {
+	var sources []corev1.ObjectReference
+	for _, src := range rule.Spec.Sources {
+		ref := src.ObjectRef
+		if ref.Kind != "ConfigMap" && ref.Kind != "Secret" {
+			return nil, fmt.Errorf("unsupported source kind %v", ref.Kind)
+		}
+		if len(names) == 0 {
+			sources = append(sources, ref)
+			continue
+		}
+		for _, name := range names {
+			if name == ref.Name || name == ref.Kind+"/"+ref.Name {
+				sources = append(sources, ref)
+				break
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no source matches %v", strings.Join(names, ","))
+	}
+	return sources, nil
+}
+
+// replicateTo creates or updates the copy of src referenced by ref, it returns true if the copy is changed.
+func (t *DefaultTrigger) replicateTo(rule *appv1alpha1.TriggerRule, selector map[string]string, src *unstructured.Unstructured, ref *corev1.ObjectReference) (bool, error) {
+	annotationKey := GetRecordKey(rule.Name, rule.Namespace)
+	ri, _, err := t.resourceFor(ref)
+	if err != nil {
+		return false, err
+	}
+	existing, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, fmt.Errorf("err get %v %s/%s: %w", ref.Kind, ref.Namespace, ref.Name, err)
+	}
+	if err == nil && existing.GetLabels()[RuleUIDLabel] != string(rule.UID) {
+		return false, fmt.Errorf("%v %s/%s already exists and is not managed by the rule", ref.Kind, ref.Namespace, ref.Name)
+	}
+
+	var annotations map[string]string
+	if err == nil {
+		annotations = existing.GetAnnotations()
+	}
+	rec, err := t.generateNewRecord(rule, annotations, annotationKey)
+	if err != nil {
+		return false, fmt.Errorf("err generate record: %w", err)
+	}
+	if rec == nil {
+		return false, nil
+	}
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return false, fmt.Errorf("err encode %#v: %w", rec, err)
+	}
+
+	lbs := map[string]string{}
+	for k, v := range src.GetLabels() {
+		lbs[k] = v
+	}
+	for k, v := range selector {
+		lbs[k] = v
+	}
+	cp := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	for _, field := range []string{"data", "binaryData", "type"} {
+		if v, ok := src.Object[field]; ok {
+			cp.Object[field] = v
+		}
+	}
+	cp.SetAPIVersion(src.GetAPIVersion())
+	cp.SetKind(src.GetKind())
+	cp.SetNamespace(ref.Namespace)
+	cp.SetName(ref.Name)
+	cp.SetLabels(lbs)
+	cp.SetAnnotations(map[string]string{annotationKey: string(val)})
+
+	if existing == nil {
+		t.logger.Info("Create replica", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name)
+		if _, err := ri.Create(cp, metav1.CreateOptions{}); err != nil {
+			return false, fmt.Errorf("err create %v %s/%s: %w", ref.Kind, ref.Namespace, ref.Name, err)
+		}
+		return true, nil
+	}
+	t.logger.Info("Update replica", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name)
+	cp.SetResourceVersion(existing.GetResourceVersion())
+	if _, err := ri.Update(cp, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("err update %v %s/%s: %w", ref.Kind, ref.Namespace, ref.Name, err)
+	}
+	return true, nil
+}
+
+// deleteReplicas deletes copies labeled by selector which are not wanted any more.
+// Both kinds are searched, so copies of sources removed from the rule are also deleted.
+func (t *DefaultTrigger) deleteReplicas(selector map[string]string, want map[corev1.ObjectReference]bool) (int, error) {
+	deleted := 0
+	for _, kind := range []string{"ConfigMap", "Secret"} {
+		// Namespace is empty, so copies in all namespaces are listed.
+		ri, _, err := t.resourceFor(&corev1.ObjectReference{Kind: kind})
+		if err != nil {
+			return deleted, err
+		}
+		list, err := ri.List(metav1.ListOptions{LabelSelector: labels.SelectorFromSet(selector).String()})
+		if err != nil {
+			return deleted, fmt.Errorf("err list %v: %w", kind, err)
+		}
+		for _, item := range list.Items {
+			ref := corev1.ObjectReference{APIVersion: "v1", Kind: kind, Namespace: item.GetNamespace(), Name: item.GetName()}
+			if want[ref] {
+				continue
+			}
+			t.logger.Info("Delete replica", "kind", kind, "namespace", ref.Namespace, "name", ref.Name)
+			ri, _, err := t.resourceFor(&ref)
+			if err != nil {
+				return deleted, err
+			}
+			if err := ri.Delete(ref.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return deleted, fmt.Errorf("err delete %v %s/%s: %w", kind, ref.Namespace, ref.Name, err)
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// rolloutInNamespace updates pod template of the workload in namespace, it returns false if the workload does not
+// exist or is already up to date.
+func (t *DefaultTrigger) rolloutInNamespace(ctx context.Context, rule *appv1alpha1.TriggerRule, rollout *appv1alpha1.ActionUpdatePodTemplate, namespace string) (bool, error) {
+	r := rollout.DeepCopy()
+	r.ObjectRef.Namespace = namespace
+	ri, _, err := t.resourceFor(&r.ObjectRef)
+	if err != nil {
+		return false, err
+	}
+	if _, err := ri.Get(r.ObjectRef.Name, metav1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("err get %v %s/%s: %w", r.ObjectRef.Kind, namespace, r.ObjectRef.Name, err)
+	}
+	status, err := t.updatePodTemplate(ctx, rule, &appv1alpha1.Action{UpdatePodTemplate: r})
+	return status != nil, err
+}
@@ -0,0 +1,150 @@
+package trigger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultGroups is used to find group of builtin kinds when APIVersion of reference is not set.
+var defaultGroups = map[string]string{
+	"Deployment":            "apps",
+	"StatefulSet":           "apps",
+	"DaemonSet":             "apps",
+	"ReplicaSet":            "apps",
+	"ReplicationController": "",
+	"Job":                   "batch",
+	"CronJob":               "batch",
+	"Pod":                   "",
+	"ConfigMap":             "",
+	"Secret":                "",
+	"Kustomization":         "kustomize.toolkit.fluxcd.io",
+	"HelmRelease":           "helm.toolkit.fluxcd.io",
+	"GitRepository":         "source.toolkit.fluxcd.io",
+	"HelmRepository":        "source.toolkit.fluxcd.io",
+	"OCIRepository":         "source.toolkit.fluxcd.io",
+	"Bucket":                "source.toolkit.fluxcd.io",
+	"Application":           "argoproj.io",
+	"Rollout":               "argoproj.io",
+}
+
+// defaultPodTemplatePaths are well-known paths of pod template, kinds not listed here use "spec.template".
+var defaultPodTemplatePaths = map[schema.GroupKind]string{
+	{Group: "batch", Kind: "CronJob"}: "spec.jobTemplate.spec.template",
+}
+
+const defaultPodTemplatePath = "spec.template"
+
+// parseFieldPath converts a simple JSONPath like "{.spec.template}" or "spec.template" to field names.
+func parseFieldPath(path string) ([]string, error) {
+	p := strings.TrimSpace(path)
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "{"), "}")
+	p = strings.TrimPrefix(p, ".")
+	if p == "" {
+		return nil, fmt.Errorf("empty path %q", path)
+	}
+	fields := strings.Split(p, ".")
+	for _, f := range fields {
+		if f == "" || strings.ContainsAny(f, "[]*") {
+			return nil, fmt.Errorf("unsupported path %q, only field names are allowed", path)
+		}
+	}
+	return fields, nil
+}
+
+// podTemplatePath returns fields of pod template path of the workload.
+func podTemplatePath(gk schema.GroupKind, path string) ([]string, error) {
+	if path == "" {
+		path = defaultPodTemplatePath
+		if p, ok := defaultPodTemplatePaths[gk]; ok {
+			path = p
+		}
+	}
+	return parseFieldPath(path)
+}
+
+// groupVersionKind returns GroupVersionKind of ref, version is empty if it is not specified in ref.
+func groupVersionKind(ref *corev1.ObjectReference) (schema.GroupVersionKind, error) {
+	if ref.Kind == "" {
+		return schema.GroupVersionKind{}, fmt.Errorf("kind of %s/%s is not specified", ref.Namespace, ref.Name)
+	}
+	if ref.APIVersion == "" {
+		group, ok := defaultGroups[ref.Kind]
+		if !ok {
+			return schema.GroupVersionKind{}, fmt.Errorf("apiVersion is required for kind %v", ref.Kind)
+		}
+		return schema.GroupVersionKind{Group: group, Kind: ref.Kind}, nil
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return schema.GroupVersionKind{}, fmt.Errorf("err parse apiVersion %v: %w", ref.APIVersion, err)
+	}
+	return gv.WithKind(ref.Kind), nil
+}
+
+// resourceFor returns a dynamic client of the resource referenced by ref.
+func (t *DefaultTrigger) resourceFor(ref *corev1.ObjectReference) (dynamic.ResourceInterface, *meta.RESTMapping, error) {
+	mapping, err := t.mappingFor(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return t.dynamic.Resource(mapping.Resource).Namespace(ref.Namespace), mapping, nil
+	}
+	return t.dynamic.Resource(mapping.Resource), mapping, nil
+}
+
+// mappingFor returns the REST mapping of the kind of ref.
+func (t *DefaultTrigger) mappingFor(ref *corev1.ObjectReference) (*meta.RESTMapping, error) {
+	gvk, err := groupVersionKind(ref)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	if gvk.Version != "" {
+		versions = append(versions, gvk.Version)
+	}
+	mapping, err := t.mapper.RESTMapping(gvk.GroupKind(), versions...)
+	if err != nil {
+		return nil, fmt.Errorf("err find resource of %v: %w", gvk, err)
+	}
+	return mapping, nil
+}
+
+// recordOnObject gets the object and generates a new record from its annotations, nil record is returned if
+// sources and spec, the hash of templates of the action, are not changed.
+func (t *DefaultTrigger) recordOnObject(rule *appv1alpha1.TriggerRule, ref *corev1.ObjectReference, spec string) (dynamic.ResourceInterface, *Record, error) {
+	ri, mapping, err := t.resourceFor(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("err get %v: %w", mapping.GroupVersionKind.Kind, err)
+	}
+	rec, err := t.generateSpecRecord(rule, obj.GetAnnotations(), GetRecordKey(rule.Name, rule.Namespace), spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("err generate record: %w", err)
+	}
+	return ri, rec, nil
+}
+
+// patchRecord sets record and other annotations to the object.
+func (t *DefaultTrigger) patchRecord(ri dynamic.ResourceInterface, rule *appv1alpha1.TriggerRule, name string, rec *Record, annotations map[string]interface{}) error {
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("err encode %#v: %w", rec, err)
+	}
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+	}
+	annotations[GetRecordKey(rule.Name, rule.Namespace)] = string(val)
+	return patchAnnotations(ri, name, annotations)
+}
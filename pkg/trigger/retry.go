@@ -0,0 +1,118 @@
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// RetryAnnotation is set on TriggerRules to retry exhausted actions, any value different from
+	// status.observedRetry requests a retry.
+	RetryAnnotation = "trigger.app.example.com/retry"
+
+	defaultMaxAttempts = 5
+	defaultBackoff     = 5 * time.Second
+	defaultMaxBackoff  = 300 * time.Second
+)
+
+// retryable returns true for transient errors of the API server or the network, e.g. conflicts, throttling and
+// connection failures, found in the chain of wrapped errors. Timeouts of kube-trigger waiting for results, e.g. of a
+// Job, are not transient.
+func retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var se *apierrors.StatusError
+	if errors.As(err, &se) {
+		return apierrors.IsConflict(se) || apierrors.IsTooManyRequests(se) || apierrors.IsServerTimeout(se) ||
+			apierrors.IsTimeout(se) || apierrors.IsServiceUnavailable(se) || apierrors.IsInternalError(se)
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// maxAttempts returns the maximum number of attempts of policy.
+func maxAttempts(policy *appv1alpha1.RetryPolicy) int32 {
+	if policy != nil && policy.MaxAttempts != nil {
+		return *policy.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// backoff returns the delay before the next attempt after attempts failed.
+func backoff(policy *appv1alpha1.RetryPolicy, attempts int32) time.Duration {
+	delay, max := defaultBackoff, defaultMaxBackoff
+	if policy != nil && policy.BackoffSeconds != nil {
+		delay = time.Duration(*policy.BackoffSeconds) * time.Second
+	}
+	if policy != nil && policy.MaxBackoffSeconds != nil {
+		max = time.Duration(*policy.MaxBackoffSeconds) * time.Second
+	}
+	for i := int32(1); i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// retryStatus records a failed attempt in status, and returns the delay before the next attempt, or 0 if the
+// action is exhausted.
+func retryStatus(status *appv1alpha1.ActionStatus, err error, policy *appv1alpha1.RetryPolicy, attempts int32, hash string) time.Duration {
+	status.Attempts, status.SourcesHash = attempts, hash
+	if !retryable(err) || attempts >= maxAttempts(policy) {
+		status.Phase = appv1alpha1.ActionExhausted
+		return 0
+	}
+	delay := backoff(policy, attempts)
+	status.Message = fmt.Sprintf("%v, attempt %d will start in %v", status.Message, attempts+1, delay)
+	return delay
+}
+
+// latestStatus returns the latest status of rule from the API server.
+func (t *DefaultTrigger) latestStatus(rule *appv1alpha1.TriggerRule) (*appv1alpha1.TriggerRuleStatus, error) {
+	if rule.UID == "" {
+		return &appv1alpha1.TriggerRuleStatus{}, nil
+	}
+	obj, err := t.dynamic.Resource(triggerRuleResource).Namespace(rule.Namespace).Get(rule.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("err get rule: %w", err)
+	}
+	latest := &appv1alpha1.TriggerRule{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, latest); err != nil {
+		return nil, fmt.Errorf("err decode rule: %w", err)
+	}
+	return &latest.Status, nil
+}
+
+// hasFailedActions returns true if any action in list failed or is exhausted.
+func hasFailedActions(list []appv1alpha1.ActionStatus) bool {
+	for i := range list {
+		if list[i].Phase == appv1alpha1.ActionFailed || list[i].Phase == appv1alpha1.ActionExhausted {
+			return true
+		}
+	}
+	return false
+}
+
+// findActionStatus returns the status of the action at index in list.
+func findActionStatus(list []appv1alpha1.ActionStatus, index int) *appv1alpha1.ActionStatus {
+	for i := range list {
+		if list[i].Index == index {
+			return &list[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestRetryable(t *testing.T) {
+	gr := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.NewConflict(gr, "foo", fmt.Errorf("changed")), true},
+		{errors.NewTooManyRequests("slow down", 1), true},
+		{errors.NewServerTimeout(gr, "patch", 1), true},
+		{fmt.Errorf("err patch workload: %w", errors.NewConflict(gr, "foo", fmt.Errorf("changed"))), true},
+		{fmt.Errorf("err get deployment: %w", errors.NewNotFound(gr, "foo")), false},
+		{fmt.Errorf("unsupported source kind Pod"), false},
+		{fmt.Errorf("err get deployment: %w", &url.Error{Op: "Get", URL: "https://10.0.0.1", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}}), true},
+		// Waiting for results is not retried, even if it timed out.
+		{fmt.Errorf("err wait for job foo-ns/foo: %w", wait.ErrWaitTimeout), false},
+		{fmt.Errorf("err patch workload: timed out"), false},
+	}
+	for _, c := range cases {
+		if got := retryable(c.err); got != c.retryable {
+			t.Errorf("retryable(%v) = %v, expect %v", c.err, got, c.retryable)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := int64(2), int64(10)
+	policy := &appv1alpha1.RetryPolicy{BackoffSeconds: &base, MaxBackoffSeconds: &max}
+	for attempts, expect := range map[int32]time.Duration{
+		1: 2 * time.Second,
+		2: 4 * time.Second,
+		3: 8 * time.Second,
+		4: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if got := backoff(policy, attempts); got != expect {
+			t.Errorf("backoff after %d attempts = %v, expect %v", attempts, got, expect)
+		}
+	}
+}
+
+func TestSelectedTemplatesErrorRetryable(t *testing.T) {
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns"},
+	}}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deploy)
+	dynamicClient.PrependReactor("get", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewTooManyRequests("slow down", 1)
+	})
+	tr := New(nil, fake.NewSimpleClientset(), dynamicClient, mapper, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Sources: []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}}},
+			Actions: []appv1alpha1.Action{{UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{
+				Selector: &appv1alpha1.TargetSelector{Kinds: []string{"Deployment"}},
+			}}},
+		},
+	}
+	status, err := tr.updateSelectedTemplates(context.Background(), rule, 0, &rule.Spec.Actions[0])
+	if status == nil || status.Phase != appv1alpha1.ActionFailed {
+		t.Fatalf("expect failed status, got %#v", status)
+	}
+	if !retryable(err) {
+		t.Errorf("expect errors of workloads kept to be retried, got %v", err)
+	}
+}
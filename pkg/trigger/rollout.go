@@ -0,0 +1,127 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	rolloutPollInterval = 2 * time.Second
+	// rolloutCheckInterval is the delay before rollouts awaited by post actions are checked again.
+	rolloutCheckInterval = 10 * time.Second
+)
+
+// waitForRollout waits until rollout of the workload completed.
+func (t *DefaultTrigger) waitForRollout(ctx context.Context, ri dynamic.ResourceInterface, name string, timeout time.Duration) error {
+	_, err := pollObject(ctx, ri, name, timeout, func(obj *unstructured.Unstructured) (bool, error) {
+		return rolloutComplete(obj), nil
+	})
+	if err != nil {
+		return fmt.Errorf("err wait for rollout of %v: %w", name, err)
+	}
+	return nil
+}
+
+// pendingRollout returns a deferred error if a workload updated by actions of rule is still rolling out, so post
+// actions run once rollouts completed, without holding a worker. Workloads selected by actions are read from the
+// targets in the latest status.
+func (t *DefaultTrigger) pendingRollout(rule *appv1alpha1.TriggerRule) error {
+	var refs []corev1.ObjectReference
+	var latest *appv1alpha1.TriggerRuleStatus
+	for i, action := range rule.Spec.Actions {
+		switch {
+		case action.UpdatePodTemplate != nil && action.UpdatePodTemplate.Selector != nil:
+			if latest == nil {
+				var err error
+				if latest, err = t.latestStatus(rule); err != nil {
+					return err
+				}
+			}
+			if status := findActionStatus(latest.Actions, i); status != nil {
+				for _, target := range status.Targets {
+					refs = append(refs, target.ObjectRef)
+				}
+			}
+		case action.UpdatePodTemplate != nil:
+			refs = append(refs, action.UpdatePodTemplate.ObjectRef)
+		case action.VersionedCopy != nil:
+			refs = append(refs, action.VersionedCopy.ObjectRef)
+		case action.Auto != nil:
+			refs = append(refs, action.Auto.ObjectRef)
+		}
+	}
+
+	for i := range refs {
+		ri, _, err := t.resourceFor(&refs[i])
+		if err != nil {
+			return err
+		}
+		obj, err := ri.Get(refs[i].Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("err get %v %v: %w", refs[i].Kind, refs[i].Name, err)
+		}
+		if !rolloutComplete(obj) {
+			return &deferredError{
+				until:  time.Now().Add(rolloutCheckInterval),
+				reason: fmt.Sprintf("rollout of %v %s/%s", refs[i].Kind, refs[i].Namespace, refs[i].Name),
+			}
+		}
+	}
+	return nil
+}
+
+// pollObject gets the object periodically until cond returns true, the last object is returned.
+func pollObject(ctx context.Context, ri dynamic.ResourceInterface, name string, timeout time.Duration, cond func(obj *unstructured.Unstructured) (bool, error)) (*unstructured.Unstructured, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var obj *unstructured.Unstructured
+	err := wait.PollImmediateUntil(rolloutPollInterval, func() (bool, error) {
+		var err error
+		obj, err = ri.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return cond(obj)
+	}, ctx.Done())
+	return obj, err
+}
+
+// rolloutComplete checks status of Deployment, StatefulSet, DaemonSet and workloads with similar status.
+// Workloads without known status fields are considered completed once the spec is observed.
+func rolloutComplete(obj *unstructured.Unstructured) bool {
+	status, found, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil || !found {
+		return false
+	}
+	if observed, found, _ := unstructured.NestedInt64(status, "observedGeneration"); found && observed < obj.GetGeneration() {
+		return false
+	}
+
+	// DaemonSet
+	if desired, found, _ := unstructured.NestedInt64(status, "desiredNumberScheduled"); found {
+		updated, _, _ := unstructured.NestedInt64(status, "updatedNumberScheduled")
+		available, _, _ := unstructured.NestedInt64(status, "numberAvailable")
+		return updated == desired && available == desired
+	}
+
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		return true
+	}
+	updated, _, _ := unstructured.NestedInt64(status, "updatedReplicas")
+	current, _, _ := unstructured.NestedInt64(status, "replicas")
+	ready, found, _ := unstructured.NestedInt64(status, "availableReplicas")
+	if !found {
+		ready, _, _ = unstructured.NestedInt64(status, "readyReplicas")
+	}
+	return updated == replicas && current == replicas && ready == replicas
+}
@@ -0,0 +1,136 @@
+package trigger
+
+import (
+	"testing"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestRolloutComplete(t *testing.T) {
+	cases := []struct {
+		name   string
+		obj    map[string]interface{}
+		expect bool
+	}{
+		{
+			name: "deployment in progress",
+			obj: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2),
+					"replicas":           int64(4),
+					"updatedReplicas":    int64(2),
+					"availableReplicas":  int64(3),
+				},
+			},
+			expect: false,
+		},
+		{
+			name: "deployment not observed",
+			obj: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": int64(3)},
+				"spec":     map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2),
+					"replicas":           int64(3),
+					"updatedReplicas":    int64(3),
+					"availableReplicas":  int64(3),
+				},
+			},
+			expect: false,
+		},
+		{
+			name: "deployment completed",
+			obj: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"spec":     map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(2),
+					"replicas":           int64(3),
+					"updatedReplicas":    int64(3),
+					"availableReplicas":  int64(3),
+				},
+			},
+			expect: true,
+		},
+		{
+			name: "statefulset completed",
+			obj: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": int64(1)},
+				"spec":     map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{
+					"observedGeneration": int64(1),
+					"replicas":           int64(2),
+					"updatedReplicas":    int64(2),
+					"readyReplicas":      int64(2),
+				},
+			},
+			expect: true,
+		},
+		{
+			name: "daemonset in progress",
+			obj: map[string]interface{}{
+				"metadata": map[string]interface{}{"generation": int64(1)},
+				"status": map[string]interface{}{
+					"observedGeneration":     int64(1),
+					"desiredNumberScheduled": int64(5),
+					"updatedNumberScheduled": int64(4),
+					"numberAvailable":        int64(5),
+				},
+			},
+			expect: false,
+		},
+	}
+	for _, c := range cases {
+		if got := rolloutComplete(&unstructured.Unstructured{Object: c.obj}); got != c.expect {
+			t.Errorf("%s: expect %v, got %v", c.name, c.expect, got)
+		}
+	}
+}
+
+func TestPendingRollout(t *testing.T) {
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns", "generation": int64(2)},
+		"spec":       map[string]interface{}{"replicas": int64(1)},
+		"status":     map[string]interface{}{"observedGeneration": int64(1)},
+	}}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	dynamic := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deploy)
+	tr := New(nil, nil, dynamic, mapper, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"},
+		Spec: appv1alpha1.TriggerRuleSpec{Actions: []appv1alpha1.Action{{UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{
+			ObjectRef: corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "foo-ns"},
+		}}}},
+	}
+
+	if _, ok := isDeferred(tr.pendingRollout(rule)); !ok {
+		t.Fatal("expect post actions deferred while the deployment rolls out")
+	}
+	deploy.Object["status"] = map[string]interface{}{
+		"observedGeneration": int64(2),
+		"replicas":           int64(1),
+		"updatedReplicas":    int64(1),
+		"availableReplicas":  int64(1),
+	}
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	if _, err := dynamic.Resource(gvr).Namespace("foo-ns").Update(deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.pendingRollout(rule); err != nil {
+		t.Errorf("expect post actions run once the rollout completed, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package trigger
+
+import (
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// setRun persists the run of rule in status, or clears it if state is empty. The time of the state is kept if
+// neither the run nor its state changed. Status is not read if the run is known to be persisted already, or no run
+// is persisted to be cleared.
+func (t *DefaultTrigger) setRun(rule *appv1alpha1.TriggerRule, state appv1alpha1.RunState, notBefore *time.Time) {
+	if rule.UID == "" {
+		return
+	}
+	key := runKey(rule)
+	id := sourcesHash(rule)
+	t.mu.Lock()
+	last := t.runs[key]
+	t.mu.Unlock()
+	switch {
+	case state == "" && last == nil:
+		// Hooks and actions had nothing to do.
+		return
+	case state != "" && last != nil && last.ID == id && last.State == state && (notBefore == nil || (last.NotBefore != nil && !last.NotBefore.After(*notBefore))):
+		// An earlier start of the same pending run is kept, a resumed run is deferred again if it is not due.
+		return
+	}
+
+	var run *appv1alpha1.RunStatus
+	if err := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+		if state == "" {
+			s.Run, run = nil, nil
+			return
+		}
+		run = &appv1alpha1.RunStatus{ID: id, State: state, Since: metav1.Now()}
+		if notBefore != nil {
+			nb := metav1.NewTime(*notBefore)
+			run.NotBefore = &nb
+		}
+		if s.Run != nil && s.Run.ID == run.ID && s.Run.State == run.State {
+			run.Since = s.Run.Since
+			if s.Run.NotBefore != nil && run.NotBefore != nil && !s.Run.NotBefore.After(run.NotBefore.Time) {
+				run.NotBefore = s.Run.NotBefore
+			}
+		}
+		s.Run = run
+	}); err != nil {
+		t.logger.Error(err, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
+		return
+	}
+	t.mu.Lock()
+	if run == nil {
+		delete(t.runs, key)
+	} else {
+		t.runs[key] = run.DeepCopy()
+	}
+	t.mu.Unlock()
+}
+
+// setRunning marks the run of rule running in s, unless a run of the same sources is persisted. It is applied
+// with results of hooks and actions, so the run is only persisted once they have something to do, without a write
+// of its own.
+func (t *DefaultTrigger) setRunning(s *appv1alpha1.TriggerRuleStatus, rule *appv1alpha1.TriggerRule) {
+	id := sourcesHash(rule)
+	if s.Run == nil || s.Run.ID != id {
+		s.Run = &appv1alpha1.RunStatus{ID: id, State: appv1alpha1.RunRunning, Since: metav1.Now()}
+	}
+	t.mu.Lock()
+	t.runs[runKey(rule)] = s.Run.DeepCopy()
+	t.mu.Unlock()
+}
+
+// hasRun returns true if a run of rule may be persisted in status.
+func (t *DefaultTrigger) hasRun(rule *appv1alpha1.TriggerRule) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.runs[runKey(rule)] != nil
+}
+
+// runKey returns the key of runs of rule, which is the rule itself since runs are persisted in its status.
+func runKey(rule *appv1alpha1.TriggerRule) types.NamespacedName {
+	return types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}
+}
+
+// resume restores the run of rule persisted in status, if the key is seen for the first time since kube-trigger
+// started, and returns the time left before a pending run should be processed. The burst of changes being debounced
+// is restored as well, so the debounce period is not restarted. A running run is processed again at once, actions
+// already done for its sources are skipped by their records. Must be called with mu held.
+func (t *DefaultTrigger) resume(key types.NamespacedName, rule *appv1alpha1.TriggerRule) time.Duration {
+	if t.seen[key] {
+		return 0
+	}
+	t.seen[key] = true
+	if rule.Status.Run != nil {
+		t.runs[runKey(rule)] = rule.Status.Run.DeepCopy()
+	}
+	if p := rule.Status.Pending; p != nil && t.bursts[key] == nil {
+		// Sources may have changed while kube-trigger was down, the hash is set by the next settle.
+		t.bursts[key] = &burst{first: p.FirstChangeTime.Time, last: p.LastChangeTime.Time}
+	}
+	run := rule.Status.Run
+	if run == nil || run.State != appv1alpha1.RunPending || run.NotBefore == nil {
+		return 0
+	}
+	return time.Until(run.NotBefore.Time)
+}
+
+// checkpoint persists the run of rule as pending, to be started at once by the next leader. A pending run of the
+// same sources is kept, so its delay is not lost.
+func (t *DefaultTrigger) checkpoint(rule *appv1alpha1.TriggerRule) {
+	if rule.UID == "" {
+		return
+	}
+	if err := t.resolveSources(rule); err != nil {
+		t.logger.Error(err, "Checkpoint failed", "rule", rule.Name, "namespace", rule.Namespace)
+		return
+	}
+	id := sourcesHash(rule)
+	now := metav1.Now()
+	if err := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+		if s.Run != nil && s.Run.State == appv1alpha1.RunPending && s.Run.ID == id {
+			return
+		}
+		s.Run = &appv1alpha1.RunStatus{ID: id, State: appv1alpha1.RunPending, Since: now, NotBefore: &now}
+	}); err != nil {
+		t.logger.Error(err, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
+	}
+}
@@ -0,0 +1,120 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResume(t *testing.T) {
+	tr := &DefaultTrigger{
+		bursts: map[types.NamespacedName]*burst{},
+		seen:   map[types.NamespacedName]bool{},
+		runs:   map[types.NamespacedName]*appv1alpha1.RunStatus{},
+	}
+	key := types.NamespacedName{Namespace: "foo-ns", Name: "foo"}
+	first := time.Now().Add(-time.Minute)
+	notBefore := metav1.NewTime(time.Now().Add(time.Hour))
+	rule := &appv1alpha1.TriggerRule{Status: appv1alpha1.TriggerRuleStatus{
+		Pending: &appv1alpha1.PendingStatus{FirstChangeTime: metav1.NewTime(first), LastChangeTime: metav1.NewTime(first)},
+		Run:     &appv1alpha1.RunStatus{ID: "a", State: appv1alpha1.RunPending, NotBefore: &notBefore},
+	}}
+
+	if d := tr.resume(key, rule); d <= 50*time.Minute {
+		t.Errorf("expect pending run resumed after about an hour, got %v", d)
+	}
+	if b := tr.bursts[key]; b == nil || !b.first.Equal(first) || b.hash != "" {
+		t.Errorf("expect burst restored from status, got %+v", b)
+	}
+	if d := tr.resume(key, rule); d != 0 {
+		t.Errorf("expect run resumed only once, got %v", d)
+	}
+
+	other := types.NamespacedName{Namespace: "foo-ns", Name: "bar"}
+	rule.Status.Run.State = appv1alpha1.RunRunning
+	if d := tr.resume(other, rule); d != 0 {
+		t.Errorf("expect running run resumed at once, got %v", d)
+	}
+}
+
+func TestRunPersistedOnlyWhenActionsRun(t *testing.T) {
+	rec, _ := json.Marshal(&Record{
+		LastUpdateTime: time.Now().UnixNano(),
+		Sources:        []Source{{Name: "foo", Namespace: "foo-ns", Kind: "ConfigMap", ResourceVersion: "1"}},
+	})
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns"},
+		"spec": map[string]interface{}{"template": map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": map[string]interface{}{GetRecordKey("foo", "foo-ns"): string(rec)}},
+		}},
+	}}
+	ruleObj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": appv1alpha1.SchemeGroupVersion.String(),
+		"kind":       "TriggerRule",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns", "uid": "foo-uid"},
+	}}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deploy, ruleObj)
+	tr := New(nil, fake.NewSimpleClientset(cm), dynamicClient, mapper, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns", UID: "foo-uid"},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Sources: []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns"}}},
+			Actions: []appv1alpha1.Action{{UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{
+				ObjectRef: corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "foo-ns"},
+			}}},
+		},
+	}
+	if _, err := tr.do(context.Background(), runKey(rule), rule); err != nil {
+		t.Fatal(err)
+	}
+	tr.setRun(rule, "", nil)
+	for _, a := range dynamicClient.Actions() {
+		if a.GetResource().Resource == "triggerrules" {
+			t.Errorf("expect status not read or written when sources are unchanged, got %v", a.GetVerb())
+		}
+	}
+}
+
+func TestSetRunKeepsEarlierPendingRun(t *testing.T) {
+	ruleObj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": appv1alpha1.SchemeGroupVersion.String(),
+		"kind":       "TriggerRule",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns", "uid": "foo-uid"},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), ruleObj)
+	tr := New(nil, fake.NewSimpleClientset(), dynamicClient, nil, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+	rule := &appv1alpha1.TriggerRule{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns", UID: "foo-uid"}}
+
+	notBefore := time.Now().Add(time.Second)
+	tr.setRun(rule, appv1alpha1.RunPending, &notBefore)
+	writes := len(dynamicClient.Actions())
+	if writes == 0 {
+		t.Fatal("expect pending run persisted")
+	}
+	later := notBefore.Add(5 * time.Second)
+	tr.setRun(rule, appv1alpha1.RunPending, &later)
+	if n := len(dynamicClient.Actions()); n != writes {
+		t.Errorf("expect the earlier pending run kept without reading status, got %d more requests", n-writes)
+	}
+}
@@ -0,0 +1,297 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// PreviousReplicasAnnotation records replicas of workload before it was scaled by kube-trigger.
+	PreviousReplicasAnnotation = "trigger.app.example.com/previous-replicas"
+	// ScaleTargetAnnotation records replicas set by a scale action until the workload is scaled, so a scale retried
+	// after its record was written sets the same replicas, instead of applying a delta again.
+	ScaleTargetAnnotation = "trigger.app.example.com/scale-target"
+	// SurgeAnnotation records replicas and maxSurge of workload before a surge, so they can be restored even if
+	// kube-trigger restarts during the rollout.
+	SurgeAnnotation = "trigger.app.example.com/surge"
+)
+
+// maxSurgePath is the path of maxSurge in Deployment like workloads.
+var maxSurgePath = []string{"spec", "strategy", "rollingUpdate", "maxSurge"}
+
+func (t *DefaultTrigger) scale(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.Scale
+	ref := &spec.ObjectRef
+	annotationKey := GetRecordKey(rule.Name, rule.Namespace)
+
+	ri, mapping, err := t.resourceFor(ref)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("err get %v: %w", mapping.GroupVersionKind.Kind, err)
+	}
+
+	// Record of scale action is kept in annotations of the workload itself.
+	annotations := obj.GetAnnotations()
+	rec, err := t.generateNewRecord(rule, annotations, annotationKey)
+	if err != nil {
+		return nil, fmt.Errorf("err generate record: %w", err)
+	}
+	pending, hasPending := annotations[ScaleTargetAnnotation]
+	if rec == nil && !hasPending {
+		return nil, nil
+	}
+
+	current, err := getReplicas(ri, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var target int32
+	if rec == nil {
+		// The record of the current sources was written, but the workload was not scaled.
+		v, err := strconv.ParseInt(pending, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("err parse annotation %v: %w", ScaleTargetAnnotation, err)
+		}
+		target = int32(v)
+	} else {
+		var previous interface{}
+		prevStr, hasPrev := annotations[PreviousReplicasAnnotation]
+		switch {
+		case spec.Replicas != nil:
+			target = *spec.Replicas
+		case spec.Delta != nil:
+			target = current + *spec.Delta
+		case spec.Restore:
+			if !hasPrev {
+				t.logger.Info("No previous replicas to restore", "namespace", ref.Namespace, "name", ref.Name)
+				target = current
+				break
+			}
+			prev, err := strconv.ParseInt(prevStr, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("err parse annotation %v: %w", PreviousReplicasAnnotation, err)
+			}
+			target = int32(prev)
+		default:
+			return nil, fmt.Errorf("one of replicas, delta and restore must be set")
+		}
+		if target < 0 {
+			target = 0
+		}
+		// Only the value before the first unrestored scale is kept.
+		if !spec.Restore && !hasPrev {
+			previous = strconv.Itoa(int(current))
+		} else if spec.Restore {
+			previous = nil
+		} else {
+			previous = prevStr
+		}
+
+		// The record is written with the target before scaling, so the target is not computed again if scaling
+		// fails.
+		val, err := json.Marshal(rec)
+		if err != nil {
+			return nil, fmt.Errorf("err encode %#v: %w", rec, err)
+		}
+		if err := patchAnnotations(ri, ref.Name, map[string]interface{}{
+			annotationKey:              string(val),
+			PreviousReplicasAnnotation: previous,
+			ScaleTargetAnnotation:      strconv.Itoa(int(target)),
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if target != current {
+		t.logger.Info("Scale workload", "namespace", ref.Namespace, "name", ref.Name, "from", current, "to", target)
+		if err := setReplicas(ri, ref.Name, target); err != nil {
+			return nil, err
+		}
+	}
+	if err := patchAnnotations(ri, ref.Name, map[string]interface{}{ScaleTargetAnnotation: nil}); err != nil {
+		return nil, err
+	}
+
+	return &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    "Scaled",
+		Message:   fmt.Sprintf("replicas %d -> %d", current, target),
+		ObjectRef: ref.DeepCopy(),
+	}, nil
+}
+
+// getReplicas returns spec.replicas of scale subresource.
+func getReplicas(ri dynamic.ResourceInterface, name string) (int32, error) {
+	sc, err := ri.Get(name, metav1.GetOptions{}, "scale")
+	if err != nil {
+		return 0, fmt.Errorf("err get scale: %w", err)
+	}
+	replicas, _, err := unstructured.NestedInt64(sc.Object, "spec", "replicas")
+	if err != nil {
+		return 0, fmt.Errorf("err get replicas: %w", err)
+	}
+	return int32(replicas), nil
+}
+
+// setReplicas updates spec.replicas of scale subresource.
+func setReplicas(ri dynamic.ResourceInterface, name string, replicas int32) error {
+	pt, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": replicas},
+	})
+	if err != nil {
+		return fmt.Errorf("err encode patch: %w", err)
+	}
+	if _, err := ri.Patch(name, types.MergePatchType, pt, metav1.UpdateOptions{}, "scale"); err != nil {
+		return fmt.Errorf("err patch scale: %w", err)
+	}
+	return nil
+}
+
+// patchAnnotations sets annotations of an object, annotations with nil value are removed.
+func patchAnnotations(ri dynamic.ResourceInterface, name string, annotations map[string]interface{}) error {
+	return mergePatch(ri, name, map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+}
+
+// surgeState is the state of workload before surge.
+type surgeState struct {
+	Replicas int32               `json:"replicas"`
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+}
+
+// startSurge raises replicas and maxSurge of obj, the original values are returned to be restored later.
+func (t *DefaultTrigger) startSurge(ri dynamic.ResourceInterface, obj *unstructured.Unstructured, surge *appv1alpha1.Surge) (*surgeState, error) {
+	name := obj.GetName()
+	state := &surgeState{}
+	// A surge is not finished, reuse the original values.
+	if v, ok := obj.GetAnnotations()[SurgeAnnotation]; ok {
+		if err := json.Unmarshal([]byte(v), state); err != nil {
+			return nil, fmt.Errorf("err decode annotation %v: %w", SurgeAnnotation, err)
+		}
+	} else {
+		if surge.Replicas > 0 {
+			replicas, err := getReplicas(ri, name)
+			if err != nil {
+				return nil, err
+			}
+			state.Replicas = replicas
+		}
+		if surge.MaxSurge != nil {
+			v, found, err := unstructured.NestedFieldCopy(obj.Object, maxSurgePath...)
+			if err != nil {
+				return nil, fmt.Errorf("err get maxSurge: %w", err)
+			}
+			if found {
+				ms := intOrStringFrom(v)
+				state.MaxSurge = &ms
+			}
+		}
+	}
+
+	val, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("err encode surge state: %w", err)
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{SurgeAnnotation: string(val)},
+		},
+	}
+	if surge.MaxSurge != nil {
+		if _, found, _ := unstructured.NestedMap(obj.Object, maxSurgePath[:len(maxSurgePath)-1]...); !found {
+			return nil, fmt.Errorf("maxSurge is not supported by %v %s", obj.GetKind(), name)
+		}
+		patch["spec"] = map[string]interface{}{
+			"strategy": map[string]interface{}{
+				"rollingUpdate": map[string]interface{}{"maxSurge": surge.MaxSurge},
+			},
+		}
+	}
+	if err := mergePatch(ri, name, patch); err != nil {
+		return nil, err
+	}
+
+	if surge.Replicas > 0 {
+		t.logger.Info("Surge workload", "namespace", obj.GetNamespace(), "name", name, "replicas", state.Replicas+surge.Replicas)
+		if err := setReplicas(ri, name, state.Replicas+surge.Replicas); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// finishSurge waits for the rollout to complete, and restores replicas and maxSurge.
+// Values are restored even if the rollout is not completed in time.
+func (t *DefaultTrigger) finishSurge(ctx context.Context, ri dynamic.ResourceInterface, name string, state *surgeState, surge *appv1alpha1.Surge) error {
+	waitErr := t.waitForRollout(ctx, ri, name, waitTimeout(surge.TimeoutSeconds))
+
+	if surge.Replicas > 0 {
+		current, err := getReplicas(ri, name)
+		if err != nil {
+			return err
+		}
+		// Replicas is changed by others (e.g. HPA) during the rollout, leave it as is.
+		if current == state.Replicas+surge.Replicas {
+			t.logger.Info("Restore workload replicas", "name", name, "replicas", state.Replicas)
+			if err := setReplicas(ri, name, state.Replicas); err != nil {
+				return err
+			}
+		}
+	}
+
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{SurgeAnnotation: nil},
+		},
+	}
+	if surge.MaxSurge != nil {
+		patch["spec"] = map[string]interface{}{
+			"strategy": map[string]interface{}{
+				"rollingUpdate": map[string]interface{}{"maxSurge": state.MaxSurge},
+			},
+		}
+	}
+	if err := mergePatch(ri, name, patch); err != nil {
+		return err
+	}
+	return waitErr
+}
+
+func mergePatch(ri dynamic.ResourceInterface, name string, patch map[string]interface{}) error {
+	pt, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("err encode patch: %w", err)
+	}
+	if _, err := ri.Patch(name, types.MergePatchType, pt, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("err patch %v: %w", name, err)
+	}
+	return nil
+}
+
+// intOrStringFrom converts value of unstructured object to IntOrString.
+func intOrStringFrom(v interface{}) intstr.IntOrString {
+	switch val := v.(type) {
+	case int64:
+		return intstr.FromInt(int(val))
+	case float64:
+		return intstr.FromInt(int(val))
+	case string:
+		return intstr.FromString(val)
+	default:
+		return intstr.FromString(fmt.Sprint(val))
+	}
+}
@@ -0,0 +1,106 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestScaleRetryDoesNotApplyDeltaAgain(t *testing.T) {
+	deploy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "foo-ns"},
+		"spec":       map[string]interface{}{"replicas": int64(1)},
+	}}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	// Merge patches are not supported by the fake client, the workload is served by reactors.
+	dynamic := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dynamic.PrependReactor("get", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, deploy.DeepCopy(), nil
+	})
+	// The workload is scaled, but the target is not cleared.
+	failed := false
+	dynamic.PrependReactor("patch", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if !failed && strings.Contains(string(patch.GetPatch()), ScaleTargetAnnotation+`":null`) {
+			failed = true
+			return true, nil, fmt.Errorf("connection refused")
+		}
+		var p map[string]interface{}
+		if err := json.Unmarshal(patch.GetPatch(), &p); err != nil {
+			return true, nil, err
+		}
+		mergeObject(deploy.Object, p)
+		return true, deploy.DeepCopy(), nil
+	})
+	tr := New(nil, nil, dynamic, mapper, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+
+	delta := int32(2)
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Sources: []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}}},
+			Actions: []appv1alpha1.Action{{Scale: &appv1alpha1.ActionScale{
+				ObjectRef: corev1.ObjectReference{Kind: "Deployment", Name: "foo", Namespace: "foo-ns"},
+				Delta:     &delta,
+			}}},
+		},
+	}
+	if _, err := tr.scale(context.Background(), rule, &rule.Spec.Actions[0]); err == nil {
+		t.Fatal("expect the scale failed")
+	}
+	status, err := tr.scale(context.Background(), rule, &rule.Spec.Actions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Message != "replicas 3 -> 3" {
+		t.Errorf("expect the recorded target kept, got %#v", status)
+	}
+
+	if replicas, _, _ := unstructured.NestedInt64(deploy.Object, "spec", "replicas"); replicas != 3 {
+		t.Errorf("expect delta applied once, got %v replicas", replicas)
+	}
+	if _, ok := deploy.GetAnnotations()[ScaleTargetAnnotation]; ok {
+		t.Error("expect the target cleared once scaled")
+	}
+	if status, err := tr.scale(context.Background(), rule, &rule.Spec.Actions[0]); status != nil || err != nil {
+		t.Errorf("expect nothing done for the same sources, got %#v, %v", status, err)
+	}
+}
+
+// mergeObject applies a JSON merge patch to obj, numbers are set as int64 like in decoded objects.
+func mergeObject(obj, patch map[string]interface{}) {
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(obj, k)
+		case map[string]interface{}:
+			sub, ok := obj[k].(map[string]interface{})
+			if !ok {
+				sub = map[string]interface{}{}
+				obj[k] = sub
+			}
+			mergeObject(sub, v)
+		case float64:
+			obj[k] = int64(v)
+		default:
+			obj[k] = v
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package trigger
+
+import (
+	"fmt"
+	"reflect"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+)
+
+var triggerRuleResource = appv1alpha1.SchemeGroupVersion.WithResource("triggerrules")
+
+// updateStatus applies fn to the latest status of rule, status is only written when it is changed by fn.
+func (t *DefaultTrigger) updateStatus(rule *appv1alpha1.TriggerRule, fn func(status *appv1alpha1.TriggerRuleStatus)) error {
+	if rule.UID == "" {
+		// Implicit rules, e.g. rules built from annotations of workloads, are not stored and have no status.
+		return nil
+	}
+	ri := t.dynamic.Resource(triggerRuleResource).Namespace(rule.Namespace)
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		obj, err := ri.Get(rule.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		latest := &appv1alpha1.TriggerRule{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, latest); err != nil {
+			return fmt.Errorf("err decode rule: %w", err)
+		}
+
+		status := latest.Status.DeepCopy()
+		fn(status)
+		if reflect.DeepEqual(status, &latest.Status) {
+			return nil
+		}
+		latest.Status = *status
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(latest)
+		if err != nil {
+			return fmt.Errorf("err encode rule: %w", err)
+		}
+		obj.Object = content
+		_, err = ri.UpdateStatus(obj, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// setActionStatus records result of an action to list, result with the same index will be replaced.
+func setActionStatus(list *[]appv1alpha1.ActionStatus, as appv1alpha1.ActionStatus) {
+	for i := range *list {
+		cur := &(*list)[i]
+		if cur.Index != as.Index {
+			continue
+		}
+		// Keep the time if nothing changed, so status will not be updated repeatedly.
+		as.LastUpdateTime = cur.LastUpdateTime
+		if !reflect.DeepEqual(*cur, as) {
+			as.LastUpdateTime = metav1.Now()
+		}
+		*cur = as
+		return
+	}
+	as.LastUpdateTime = metav1.Now()
+	*list = append(*list, as)
+}
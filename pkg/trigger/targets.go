@@ -0,0 +1,170 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// updateSelectedTemplates updates pod template of each workload selected by the action. Every workload keeps its
+// own record, so workloads already updated for the current sources are left alone, and their previous results are
+// kept in targets of the status.
+func (t *DefaultTrigger) updateSelectedTemplates(ctx context.Context, rule *appv1alpha1.TriggerRule, index int, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.UpdatePodTemplate
+	targets, err := t.selectTargets(rule, spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	// Status of rule may be stale, previous results are read from the latest status.
+	latest, err := t.latestStatus(rule)
+	if err != nil {
+		return nil, err
+	}
+	var last []appv1alpha1.TargetStatus
+	for _, as := range latest.Actions {
+		if as.Index == index {
+			last = as.Targets
+		}
+	}
+
+	// Records are submitted without waiting for workloads to be patched, so workloads are patched in parallel.
+	changed := false
+	failed := 0
+	var firstErr error
+	var deferred *deferredError
+	var results []appv1alpha1.TargetStatus
+	for _, ref := range targets {
+		single := spec.DeepCopy()
+		single.ObjectRef, single.Selector = ref, nil
+		status, err := t.updatePodTemplate(ctx, rule, &appv1alpha1.Action{UpdatePodTemplate: single})
+		if d, ok := isDeferred(err); ok {
+			deferred = earlier(deferred, d)
+			err = nil
+		}
+		result := appv1alpha1.TargetStatus{ObjectRef: ref}
+		switch {
+		case err != nil:
+			result.Phase, result.Reason, result.Message = appv1alpha1.ActionFailed, "Error", err.Error()
+			if status != nil {
+				result.Reason = status.Reason
+			}
+		case status != nil:
+			result.Phase, result.Reason, result.Message = status.Phase, status.Reason, status.Message
+		default:
+			if prev := findTarget(last, &ref); prev != nil {
+				result = *prev
+			} else {
+				result.Phase, result.Reason = appv1alpha1.ActionSucceeded, "UpToDate"
+			}
+		}
+		if err != nil || status != nil {
+			changed = true
+		}
+		if result.Phase == appv1alpha1.ActionFailed {
+			failed++
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		results = append(results, result)
+	}
+	if !changed && reflect.DeepEqual(results, last) {
+		return nil, nil
+	}
+
+	status := &appv1alpha1.ActionStatus{
+		Phase:   appv1alpha1.ActionSucceeded,
+		Reason:  "PodTemplatesUpdated",
+		Message: fmt.Sprintf("%d workloads selected", len(results)),
+		Targets: results,
+	}
+	if failed > 0 {
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "UpdateFailed"
+		status.Message = fmt.Sprintf("%d of %d workloads failed", failed, len(results))
+		if firstErr == nil {
+			// Failures of previous attempts are kept for workloads up to date.
+			return status, fmt.Errorf("%v", status.Message)
+		}
+		// The first error is wrapped, so the action is retried if it is retryable.
+		return status, fmt.Errorf("%s: %w", status.Message, firstErr)
+	}
+	if deferred != nil {
+		// Workloads in cooldown or being rolled out are checked again when the earliest of them is due.
+		status.Phase = appv1alpha1.ActionRunning
+		return status, deferred
+	}
+	return status, nil
+}
+
+// selectTargets lists workloads of the kinds matching the selector in namespaces matching the namespace selector.
+func (t *DefaultTrigger) selectTargets(rule *appv1alpha1.TriggerRule, s *appv1alpha1.TargetSelector) ([]corev1.ObjectReference, error) {
+	kinds := s.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultDiscoveryKinds
+	}
+	listOptions := metav1.ListOptions{}
+	if s.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(s.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("err parse selector: %w", err)
+		}
+		listOptions.LabelSelector = selector.String()
+	}
+
+	namespaces := []string{rule.Namespace}
+	if s.NamespaceSelector != nil {
+		nsSelector, err := metav1.LabelSelectorAsSelector(s.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("err parse namespaceSelector: %w", err)
+		}
+		nsList, err := t.client.CoreV1().Namespaces().List(metav1.ListOptions{LabelSelector: nsSelector.String()})
+		if err != nil {
+			return nil, fmt.Errorf("err list namespaces: %w", err)
+		}
+		namespaces = nil
+		for _, ns := range nsList.Items {
+			if ns.Status.Phase != corev1.NamespaceTerminating {
+				namespaces = append(namespaces, ns.Name)
+			}
+		}
+	}
+
+	var targets []corev1.ObjectReference
+	for _, ns := range namespaces {
+		for _, kind := range kinds {
+			ri, mapping, err := t.resourceFor(&corev1.ObjectReference{Kind: kind, Namespace: ns})
+			if err != nil {
+				return nil, err
+			}
+			list, err := ri.List(listOptions)
+			if err != nil {
+				return nil, fmt.Errorf("err list %v: %w", kind, err)
+			}
+			for _, item := range list.Items {
+				targets = append(targets, corev1.ObjectReference{
+					APIVersion: mapping.GroupVersionKind.GroupVersion().String(),
+					Kind:       kind,
+					Namespace:  ns,
+					Name:       item.GetName(),
+				})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// findTarget returns the result of the workload in list.
+func findTarget(list []appv1alpha1.TargetStatus, ref *corev1.ObjectReference) *appv1alpha1.TargetStatus {
+	for i := range list {
+		if r := list[i].ObjectRef; r.Kind == ref.Kind && r.Namespace == ref.Namespace && r.Name == ref.Name {
+			return &list[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package trigger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// templateData is the data used to render templates of actions.
+type templateData struct {
+	Rule    templateRule
+	Sources []templateSource
+	// Record is the record of sources generated for this execution.
+	Record *Record
+	// Hash is a short hash of versions of sources.
+	Hash string
+}
+
+type templateRule struct {
+	Name      string
+	Namespace string
+}
+
+type templateSource struct {
+	Kind            string
+	Name            string
+	Namespace       string
+	ResourceVersion string
+	Labels          map[string]string
+	Annotations     map[string]string
+	// Data is data of ConfigMap or decoded data of Secret.
+	Data map[string]string
+}
+
+// templateData fetches sources of rule to build data for templates.
+func (t *DefaultTrigger) templateData(rule *appv1alpha1.TriggerRule, rec *Record) (*templateData, error) {
+	data := &templateData{
+		Rule:   templateRule{Name: rule.Name, Namespace: rule.Namespace},
+		Record: rec,
+	}
+	if rec != nil {
+		data.Hash = rec.hash()
+	}
+	for i := range rule.Spec.Sources {
+		ref := &rule.Spec.Sources[i].ObjectRef
+		src := templateSource{Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace}
+		switch ref.Kind {
+		case "ConfigMap":
+			cm, err := t.client.CoreV1().ConfigMaps(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("err get configmap: %w", err)
+			}
+			src.ResourceVersion, src.Labels, src.Annotations = cm.ResourceVersion, cm.Labels, cm.Annotations
+			src.Data = map[string]string{}
+			for k, v := range cm.Data {
+				src.Data[k] = v
+			}
+			for k, v := range cm.BinaryData {
+				src.Data[k] = string(v)
+			}
+		case "Secret":
+			sc, err := t.client.CoreV1().Secrets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("err get secret: %w", err)
+			}
+			src.ResourceVersion, src.Labels, src.Annotations = sc.ResourceVersion, sc.Labels, sc.Annotations
+			src.Data = map[string]string{}
+			for k, v := range sc.Data {
+				src.Data[k] = string(v)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported source kind %v", ref.Kind)
+		}
+		data.Sources = append(data.Sources, src)
+	}
+	return data, nil
+}
+
+// Source returns the source with the name, the first source matching kind and name is returned if
+// kind is specified like "Secret/foo".
+func (d *templateData) Source(name string) (*templateSource, error) {
+	for i := range d.Sources {
+		src := &d.Sources[i]
+		if src.Name == name || src.Kind+"/"+src.Name == name {
+			return src, nil
+		}
+	}
+	return nil, fmt.Errorf("source %v not found", name)
+}
+
+// templateFuncs are functions available in templates. Names and order of arguments follow sprig, so arguments can
+// be piped in the same way, e.g. {{ .Data.name | default "foo" | upper }}.
+var templateFuncs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"sha256sum": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	"toJson": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"toPrettyJson": func(v interface{}) (string, error) {
+		b, err := json.MarshalIndent(v, "", "  ")
+		return string(b), err
+	},
+	"fromJson": func(s string) (map[string]interface{}, error) {
+		m := map[string]interface{}{}
+		err := json.Unmarshal([]byte(s), &m)
+		return m, err
+	},
+	"toYaml": func(v interface{}) (string, error) {
+		b, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(b), "\n"), err
+	},
+	"fromYaml": func(s string) (map[string]interface{}, error) {
+		m := map[string]interface{}{}
+		err := yaml.Unmarshal([]byte(s), &m)
+		return m, err
+	},
+	"quote":      strconv.Quote,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+	"indent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+		return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+	"nindent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+		return "\n" + pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+	"default": func(d, v interface{}) interface{} {
+		if empty(v) {
+			return d
+		}
+		return v
+	},
+	"required": func(msg string, v interface{}) (interface{}, error) {
+		if empty(v) {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return v, nil
+	},
+	"list": func(v ...interface{}) []interface{} { return v },
+	"dict": func(v ...interface{}) (map[string]interface{}, error) {
+		if len(v)%2 != 0 {
+			return nil, fmt.Errorf("dict requires even number of arguments")
+		}
+		m := map[string]interface{}{}
+		for i := 0; i < len(v); i += 2 {
+			m[fmt.Sprint(v[i])] = v[i+1]
+		}
+		return m, nil
+	},
+	// merge merges maps deeply, values in dst and earlier maps take precedence.
+	"merge": func(dst map[string]interface{}, srcs ...map[string]interface{}) map[string]interface{} {
+		for _, src := range srcs {
+			mergeMap(dst, src, false)
+		}
+		return dst
+	},
+	// mergeOverwrite merges maps deeply, values in later maps take precedence.
+	"mergeOverwrite": func(dst map[string]interface{}, srcs ...map[string]interface{}) map[string]interface{} {
+		for _, src := range srcs {
+			mergeMap(dst, src, true)
+		}
+		return dst
+	},
+}
+
+// mergeMap merges src into dst recursively, existing values in dst are replaced only if overwrite is true.
+func mergeMap(dst, src map[string]interface{}, overwrite bool) {
+	for k, v := range src {
+		dv, exist := dst[k]
+		dm, dOK := dv.(map[string]interface{})
+		sm, sOK := v.(map[string]interface{})
+		switch {
+		case dOK && sOK:
+			mergeMap(dm, sm, overwrite)
+		case !exist || overwrite:
+			dst[k] = v
+		}
+	}
+}
+
+// join joins elements of a list of any type with sep.
+func join(sep string, v interface{}) string {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return fmt.Sprint(v)
+	}
+	var elems []string
+	for i := 0; i < val.Len(); i++ {
+		elems = append(elems, fmt.Sprint(val.Index(i).Interface()))
+	}
+	return strings.Join(elems, sep)
+}
+
+// empty returns true if v is nil or zero value of its type, or an empty collection.
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return val.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return val.IsNil()
+	default:
+		return reflect.DeepEqual(v, reflect.Zero(val.Type()).Interface())
+	}
+}
+
+// renderTemplate executes text as a Go template with data.
+func renderTemplate(name, text string, data interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("err parse template %v: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("err render template %v: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
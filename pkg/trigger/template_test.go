@@ -0,0 +1,80 @@
+package trigger
+
+import (
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := &templateData{
+		Rule: templateRule{Name: "foo", Namespace: "foo-ns"},
+		Sources: []templateSource{
+			{Kind: "ConfigMap", Name: "cm", Data: map[string]string{"version": "v2"}},
+			{Kind: "Secret", Name: "cm", Data: map[string]string{"token": "abc"}},
+		},
+		Hash: "0123abcd",
+	}
+
+	out, err := renderTemplate("test", `{"metadata":{"annotations":{"version":{{ (.Source "cm").Data.version | quote }},"token":"{{ (.Source "Secret/cm").Data.token | b64enc }}","hash":"{{ .Hash }}"}}}`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"metadata":{"annotations":{"version":"v2","token":"YWJj","hash":"0123abcd"}}}`
+	if string(out) != expect {
+		t.Errorf("Expect %v, got %v", expect, string(out))
+	}
+
+	if _, err := renderTemplate("test", `{{ (.Source "missing").Data }}`, data); err == nil {
+		t.Error("Expect error for missing source")
+	}
+	if _, err := renderTemplate("test", `{{ (.Source "cm").Data.missing }}`, data); err == nil {
+		t.Error("Expect error for missing key")
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	data := &templateData{
+		Sources: []templateSource{
+			{Kind: "ConfigMap", Name: "base", Data: map[string]string{"config.yaml": "log:\n  level: info\n  format: json\nport: 80\n"}},
+			{Kind: "ConfigMap", Name: "override", Data: map[string]string{"config.json": `{"log":{"level":"debug"}}`, "name": ""}},
+		},
+	}
+
+	cases := []struct {
+		text   string
+		expect string
+	}{
+		{
+			text:   `{{ mergeOverwrite (fromYaml (index (.Source "base").Data "config.yaml")) (fromJson (index (.Source "override").Data "config.json")) | toYaml }}`,
+			expect: "log:\n  format: json\n  level: debug\nport: 80",
+		},
+		{
+			text:   `{{ merge (fromYaml (index (.Source "base").Data "config.yaml")) (fromJson (index (.Source "override").Data "config.json")) | toJson }}`,
+			expect: `{"log":{"format":"json","level":"info"},"port":80}`,
+		},
+		{
+			text:   `{{ (.Source "override").Data.name | default "app" | upper }}`,
+			expect: "APP",
+		},
+		{
+			text:   `{{ list "a" "b" | join "," }} {{ "x,y" | splitList "," | join "-" }} {{ "v1.2" | trimPrefix "v" }}`,
+			expect: "a,b x-y 1.2",
+		},
+		{
+			text:   `config:{{ dict "a" 1 | toYaml | nindent 2 }}`,
+			expect: "config:\n  a: 1",
+		},
+	}
+	for _, c := range cases {
+		out, err := renderTemplate("test", c.text, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != c.expect {
+			t.Errorf("Expect %q, got %q", c.expect, string(out))
+		}
+	}
+
+	if _, err := renderTemplate("test", `{{ (.Source "override").Data.name | required "name is required" }}`, data); err == nil {
+		t.Error("Expect error for required value")
+	}
+}
@@ -4,16 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
 	"github.com/go-logr/logr"
 	"golang.org/x/sync/errgroup"
-	"k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
 
 var (
@@ -22,12 +32,47 @@ var (
 	global Trigger
 )
 
+const (
+	// DefaultWorkers is the default number of rules processed concurrently.
+	DefaultWorkers = 4
+	// DefaultDrainTimeout is the default time rules being processed are given to finish when the trigger stops.
+	DefaultDrainTimeout = 20 * time.Second
+
+	// cancelGracePeriod is the time canceled rules are given to persist their runs after the drain timeout.
+	cancelGracePeriod = 5 * time.Second
+)
+
+// Options configures the trigger.
+type Options struct {
+	// Workers is the number of rules processed concurrently, defaults to DefaultWorkers.
+	Workers int
+	// BatchWindow is the time records for a workload are collected before they are patched together, defaults
+	// to DefaultBatchWindow.
+	BatchWindow time.Duration
+	// MaxRollouts limits the number of rollouts in progress, no limit if 0.
+	MaxRollouts int
+	// MaxRolloutsPerNamespace limits the number of rollouts in progress in a namespace, no limit if 0.
+	MaxRolloutsPerNamespace int
+	// MaxRolloutsPerNodePool limits the number of rollouts in progress on a node pool, no limit if 0.
+	MaxRolloutsPerNodePool int
+	// NodePoolLabel is the label of nodes naming their node pool.
+	NodePoolLabel string
+	// RolloutJitter is the maximum random delay before starting a rollout.
+	RolloutJitter time.Duration
+	// DrainTimeout is the time rules being processed are given to finish when the trigger stops, defaults to
+	// DefaultDrainTimeout.
+	DrainTimeout time.Duration
+	// TrustedNamespaces are namespaces whose rules may access other namespaces and cluster-scoped objects, rules in
+	// all namespaces are trusted if it contains AllNamespaces. Other rules only access their own namespace.
+	TrustedNamespaces []string
+}
+
 // Init muse be called before using global instance.
-func Init(client kubernetes.Interface, logger logr.Logger) {
+func Init(config *rest.Config, client kubernetes.Interface, dynamicClient dynamic.Interface, mapper meta.RESTMapper, logger logr.Logger, opts Options) {
 	if global != nil {
 		panic("Trigger should not be init more than once")
 	}
-	global = New(client, logger)
+	global = New(config, client, dynamicClient, mapper, logger, opts)
 	global.Start()
 }
 
@@ -48,7 +93,8 @@ type Trigger interface {
 	Add(key types.NamespacedName, rule *appv1alpha1.TriggerRule)
 	// Start starts running the trigger.
 	Start()
-	// Stop stops the trigger.
+	// Stop stops the trigger. New events are not accepted, events being processed are given a deadline to
+	// finish, and the others are persisted to be processed by the next leader.
 	Stop()
 }
 
@@ -59,99 +105,434 @@ type DefaultTrigger struct {
 	ctx    context.Context
 	cancel func()
 	logger logr.Logger
+	// config is used to exec in pods.
+	config *rest.Config
 	client kubernetes.Interface
-	mu     sync.Mutex
-	events map[types.NamespacedName]*appv1alpha1.TriggerRule
+	// dynamic and mapper are used to access workloads of arbitrary kinds.
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+	workers int
+	// trustedNamespaces are namespaces whose rules may access other namespaces.
+	trustedNamespaces map[string]bool
+	// wg tracks workers, which are given drainTimeout to finish when the trigger stops.
+	wg           sync.WaitGroup
+	drainTimeout time.Duration
+	// targets serializes and batches updates of pod templates per workload.
+	targets *targetCoordinator
+	// budget limits rollouts in progress.
+	budget *rolloutBudget
+	// informers caches nodes, only if rollouts are limited per node pool.
+	informers   informers.SharedInformerFactory
+	nodes       corelisters.NodeLister
+	nodesSynced cache.InformerSynced
+	// queue holds keys of rules to process in order, a key is never processed by two workers at the same time.
+	// Keys taken from queue are moved to ready, where workers take them by priority of rules.
+	queue workqueue.RateLimitingInterface
+	ready *readyQueue
+	// mu guards rules, which holds the latest rule of each key in queue, bursts of changes being debounced,
+	// settled, which holds the hash of sources of the last settled burst of each key, seen, which holds keys whose
+	// runs persisted in status have been resumed, runs, which holds runs last known to be persisted in status of
+	// rules, verifications, which holds refreshed pods to be verified, and stopping.
+	mu       sync.Mutex
+	rules    map[types.NamespacedName]*appv1alpha1.TriggerRule
+	bursts   map[types.NamespacedName]*burst
+	settled  map[types.NamespacedName]string
+	seen     map[types.NamespacedName]bool
+	runs     map[types.NamespacedName]*appv1alpha1.RunStatus
+	stopping bool
+	// verifications are keyed by rule and pods refreshed by an action.
+	verifications map[string]*verification
 }
 
 // New creates a new trigger
-func New(client kubernetes.Interface, logger logr.Logger) Trigger {
+func New(config *rest.Config, client kubernetes.Interface, dynamicClient dynamic.Interface, mapper meta.RESTMapper, logger logr.Logger, opts Options) Trigger {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &DefaultTrigger{
-		ctx:    ctx,
-		cancel: cancel,
-		client: client,
-		logger: logger,
-		events: make(map[types.NamespacedName]*appv1alpha1.TriggerRule),
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	window := opts.BatchWindow
+	if window <= 0 {
+		window = DefaultBatchWindow
+	}
+	if logger == nil {
+		logger = logf.NullLogger{}
+	}
+	drainTimeout := opts.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	trusted := map[string]bool{}
+	for _, ns := range opts.TrustedNamespaces {
+		trusted[ns] = true
+	}
+	t := &DefaultTrigger{
+		ctx:               ctx,
+		cancel:            cancel,
+		config:            config,
+		client:            client,
+		dynamic:           dynamicClient,
+		mapper:            mapper,
+		logger:            logger,
+		workers:           workers,
+		trustedNamespaces: trusted,
+		drainTimeout:      drainTimeout,
+		targets:           newTargetCoordinator(window),
+		budget:            newRolloutBudget(opts),
+		queue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "trigger"),
+		ready:             newReadyQueue(),
+		rules:             make(map[types.NamespacedName]*appv1alpha1.TriggerRule),
+		bursts:            make(map[types.NamespacedName]*burst),
+		settled:           make(map[types.NamespacedName]string),
+		seen:              make(map[types.NamespacedName]bool),
+		runs:              make(map[types.NamespacedName]*appv1alpha1.RunStatus),
+		verifications:     make(map[string]*verification),
+	}
+	if t.budget.perNodePool > 0 && t.budget.nodePoolLabel != "" {
+		// Node pools of every rollout are looked up from nodes of its pods, so nodes are cached.
+		t.informers = informers.NewSharedInformerFactory(client, 0)
+		nodes := t.informers.Core().V1().Nodes()
+		t.nodes, t.nodesSynced = nodes.Lister(), nodes.Informer().HasSynced
 	}
+	return t
 }
 
 // Start implements Trigger.
 func (t *DefaultTrigger) Start() {
-	go t.process()
+	if t.informers != nil {
+		t.informers.Start(t.ctx.Done())
+	}
+	go t.dispatch()
+	t.wg.Add(t.workers)
+	for i := 0; i < t.workers; i++ {
+		go t.process()
+	}
 }
 
-// Stop implements Trigger.
+// Stop implements Trigger. Rules being processed are canceled if they do not finish within the drain timeout. Rules
+// left in queue, and rules canceled, are persisted in status as pending runs, which the next leader resumes.
 func (t *DefaultTrigger) Stop() {
+	t.mu.Lock()
+	t.stopping = true
+	t.mu.Unlock()
+	t.queue.ShutDown()
+
+	t.logger.Info("Drain", "timeout", t.drainTimeout)
+	if !waitGroup(&t.wg, t.drainTimeout) {
+		t.logger.Info("Drain timeout, cancel rules in progress")
+		t.cancel()
+		if !waitGroup(&t.wg, cancelGracePeriod) {
+			t.logger.Info("Rules in progress not canceled in time")
+		}
+	}
 	t.cancel()
+
+	t.mu.Lock()
+	rules := t.rules
+	t.rules = make(map[types.NamespacedName]*appv1alpha1.TriggerRule)
+	t.mu.Unlock()
+	for key, rule := range rules {
+		t.logger.Info("Hand off", "key", key)
+		t.checkpoint(rule)
+	}
+}
+
+// waitGroup waits for wg, it returns false if wg is not done within timeout.
+func waitGroup(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 // Add implements Trigger.
 func (t *DefaultTrigger) Add(key types.NamespacedName, rule *appv1alpha1.TriggerRule) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	v, exist := t.events[key]
-	if exist && v.ResourceVersion > rule.ResourceVersion {
+	if t.stopping {
+		t.mu.Unlock()
+		return
+	}
+	// A rule of an older generation than the pending one is stale and ignored.
+	v, exist := t.rules[key]
+	if !exist || v.Generation <= rule.Generation {
+		t.rules[key] = rule
+	}
+	delay := t.resume(key, rule)
+	t.mu.Unlock()
+	if delay > 0 {
+		t.logger.Info("Resume pending run", "key", key, "after", delay)
+		t.queue.AddAfter(key, delay)
 		return
 	}
-	t.events[key] = rule
+	t.queue.Add(key)
 }
 
-// TODO:
-// 1. add multiple workers
-// 2. use sync.Cond
+// process runs a worker until the queue is shut down.
 func (t *DefaultTrigger) process() {
+	defer t.wg.Done()
+	for t.processNext() {
+	}
+	t.logger.Info("Quit.")
+}
+
+// dispatch moves keys from queue to ready with priority of their rules, until the queue is shut down.
+func (t *DefaultTrigger) dispatch() {
+	defer t.ready.close()
 	for {
-		select {
-		case <-t.ctx.Done():
-			// TODO: process the remaining items
-			t.logger.Info("Quit.")
+		item, shutdown := t.queue.Get()
+		if shutdown {
 			return
-		default:
 		}
-
+		key := item.(types.NamespacedName)
+		var priority int32
 		t.mu.Lock()
-		if len(t.events) > 0 {
-			var (
-				key  types.NamespacedName
-				rule *appv1alpha1.TriggerRule
-			)
-			for key, rule = range t.events {
-				break
+		if rule, exist := t.rules[key]; exist {
+			priority = rule.Spec.Priority
+		}
+		t.mu.Unlock()
+		t.ready.push(key, priority)
+	}
+}
+
+// processNext processes the key with the highest priority in ready, it returns false when the queue is shut down or
+// the trigger is stopping.
+func (t *DefaultTrigger) processNext() bool {
+	key, ok := t.ready.pop()
+	if !ok {
+		return false
+	}
+	defer t.queue.Done(key)
+
+	t.mu.Lock()
+	if t.stopping {
+		// The rule is kept to be handed off.
+		t.mu.Unlock()
+		return false
+	}
+	rule, exist := t.rules[key]
+	delete(t.rules, key)
+	t.mu.Unlock()
+	if !exist {
+		t.queue.Forget(key)
+		return true
+	}
+
+	t.logger.Info("Process", "key", key)
+	after, err := t.do(t.ctx, key, rule)
+	if t.ctx.Err() != nil {
+		// Canceled by Stop.
+		t.checkpoint(rule)
+		return false
+	}
+	if err != nil {
+		t.logger.Error(err, "Action failed", "rule", rule)
+	}
+	switch {
+	case after > 0:
+		t.logger.Info("Process later", "key", key, "after", after)
+		notBefore := time.Now().Add(after)
+		t.setRun(rule, appv1alpha1.RunPending, &notBefore)
+		t.requeue(key, rule, after)
+	case err != nil:
+		// Failures without a delay of their own, e.g. sources can not be read, are backed off by the queue. The run
+		// persisted so far is kept, since it is not done.
+		t.keep(key, rule)
+		t.queue.AddRateLimited(key)
+	default:
+		t.setRun(rule, "", nil)
+		t.queue.Forget(key)
+	}
+	return true
+}
+
+// requeue adds the key back to queue after delay, rule is kept unless a newer one has been added.
+func (t *DefaultTrigger) requeue(key types.NamespacedName, rule *appv1alpha1.TriggerRule, delay time.Duration) {
+	t.keep(key, rule)
+	t.queue.AddAfter(key, delay)
+}
+
+// keep stores rule to be processed again, unless a newer one has been added.
+func (t *DefaultTrigger) keep(key types.NamespacedName, rule *appv1alpha1.TriggerRule) {
+	t.mu.Lock()
+	if _, exist := t.rules[key]; !exist {
+		t.rules[key] = rule
+	}
+	t.mu.Unlock()
+}
+
+// do executes the rule. If the rule should be processed again, e.g. to retry failed actions or after the debounce
+// period, the delay is returned.
+func (t *DefaultTrigger) do(ctx context.Context, key types.NamespacedName, rule *appv1alpha1.TriggerRule) (time.Duration, error) {
+	if err := t.resolveSources(rule); err != nil {
+		return 0, err
+	}
+
+	if wait := t.settle(key, rule); wait > 0 {
+		return wait, nil
+	}
+
+	// Attempts of hooks and actions failed for the current sources are read from the latest status, since status of
+	// rule may be stale when it is retried. Status is only read if a run is not done, or hooks or actions have failed.
+	hash := sourcesHash(rule)
+	retryRequest := rule.Annotations[RetryAnnotation]
+	latest := &rule.Status
+	if t.hasRun(rule) || hasFailedActions(rule.Status.Actions) || hasFailedActions(rule.Status.PreActions) ||
+		hasFailedActions(rule.Status.PostActions) || retryRequest != rule.Status.ObservedRetry {
+		var err error
+		if latest, err = t.latestStatus(rule); err != nil {
+			t.logger.Error(err, "Get status failed", "rule", rule.Name, "namespace", rule.Namespace)
+			latest = &rule.Status
+		}
+	}
+	manualRetry := retryRequest != latest.ObservedRetry
+	observeRetry := func() {
+		if !manualRetry {
+			return
+		}
+		if sErr := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+			s.ObservedRetry = retryRequest
+		}); sErr != nil {
+			t.logger.Error(sErr, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
+		}
+	}
+
+	if after, err := t.runHooks(ctx, rule, hookPre, rule.Spec.PreActions, latest, manualRetry); err != nil {
+		observeRetry()
+		return after, fmt.Errorf("err execute pre actions: %w", err)
+	}
+
+	var (
+		mu         sync.Mutex
+		retryAfter time.Duration
+		deferred   *deferredError
+	)
+	var actionG errgroup.Group
+	for i := range rule.Spec.Actions {
+		i := i
+		actionG.Go(func() error {
+			var attempts int32
+			prev := findActionStatus(latest.Actions, i)
+			if prev != nil && prev.SourcesHash == hash && !manualRetry {
+				if prev.Phase == appv1alpha1.ActionExhausted {
+					return nil
+				}
+				attempts = prev.Attempts
 			}
-			delete(t.events, key)
-			t.mu.Unlock()
 
-			t.logger.Info("Process", "key", key)
-			if err := t.do(t.ctx, rule); err != nil {
-				t.logger.Error(err, "Action failed", "rule", rule)
+			// rule will only be read in following process, it's ok to not make a copy
+			status, err := t.action(ctx, rule, i)
+			if err != nil && ctx.Err() != nil {
+				// Interrupted by Stop, status is left for the resumed run.
+				return err
+			}
+			if err != nil && status == nil {
+				status = &appv1alpha1.ActionStatus{Phase: appv1alpha1.ActionFailed, Reason: "Error", Message: err.Error()}
+			}
+			if d, ok := isDeferred(err); ok {
+				err = nil
+				mu.Lock()
+				deferred = earlier(deferred, d)
+				mu.Unlock()
+			}
+			switch {
+			case err != nil:
+				delay := retryStatus(status, err, rule.Spec.Actions[i].Retry, attempts+1, hash)
+				mu.Lock()
+				if delay > 0 && (retryAfter == 0 || delay < retryAfter) {
+					retryAfter = delay
+				}
+				mu.Unlock()
+			case status == nil && prev != nil && (prev.Phase == appv1alpha1.ActionFailed || prev.Phase == appv1alpha1.ActionExhausted):
+				// Nothing left to do after failed attempts, e.g. the failure was fixed by others.
+				status = &appv1alpha1.ActionStatus{Phase: appv1alpha1.ActionSucceeded, Reason: "Recovered", ObjectRef: prev.ObjectRef}
+			}
+			if status != nil {
+				status.Index = i
+				if sErr := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+					t.setRunning(s, rule)
+					setActionStatus(&s.Actions, *status)
+				}); sErr != nil {
+					t.logger.Error(sErr, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
+				}
+			}
+			return err
+		})
+	}
+	if rule.Spec.Discover != nil {
+		actionG.Go(func() error {
+			err := t.discover(ctx, rule)
+			if d, ok := isDeferred(err); ok {
+				mu.Lock()
+				deferred = earlier(deferred, d)
+				mu.Unlock()
+				return nil
 			}
-		} else {
-			t.mu.Unlock()
-			time.Sleep(1 * time.Second)
+			return err
+		})
+	}
+	err := actionG.Wait()
+	if deferred != nil {
+		delay := time.Until(deferred.until)
+		if delay < time.Second {
+			delay = time.Second
+		}
+		if retryAfter == 0 || delay < retryAfter {
+			retryAfter = delay
 		}
 	}
+	observeRetry()
+	if err != nil {
+		return retryAfter, fmt.Errorf("err execute actions: %w", err)
+	}
+	if retryAfter > 0 {
+		// Actions are deferred, e.g. by a cooldown or a rollout in progress, post actions run once they are done.
+		return retryAfter, nil
+	}
+
+	if len(rule.Spec.PostActions) > 0 && t.hasRun(rule) {
+		// Post actions run once updated workloads rolled out.
+		if err := t.pendingRollout(rule); err != nil {
+			if d, ok := isDeferred(err); ok {
+				return time.Until(d.until), nil
+			}
+			return 0, fmt.Errorf("err check rollouts: %w", err)
+		}
+	}
+	if after, err := t.runHooks(ctx, rule, hookPost, rule.Spec.PostActions, latest, manualRetry); err != nil {
+		return after, fmt.Errorf("err execute post actions: %w", err)
+	}
+
+	return 0, nil
 }
 
-func (t *DefaultTrigger) do(ctx context.Context, rule *appv1alpha1.TriggerRule) error {
-	// Get last state of sources, and set ResourceVersion
+// resolveSources gets the last state of sources of rule, and sets their ResourceVersion.
+func (t *DefaultTrigger) resolveSources(rule *appv1alpha1.TriggerRule) error {
 	var g errgroup.Group
 	for i := range rule.Spec.Sources {
 		src := &rule.Spec.Sources[i]
 		ref := &src.ObjectRef
+		if err := t.checkNamespace(rule, ref.Namespace); err != nil {
+			return fmt.Errorf("source %v/%v: %w", ref.Kind, ref.Name, err)
+		}
 		g.Go(func() error {
 			switch ref.Kind {
 			case "ConfigMap":
 				cm, err := t.client.CoreV1().ConfigMaps(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
 				if err != nil {
-					return fmt.Errorf("err get configmap: %v", err)
+					return fmt.Errorf("err get configmap: %w", err)
 				}
 				ref.ResourceVersion = cm.ResourceVersion
 			case "Secret":
 				sc, err := t.client.CoreV1().Secrets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
 				if err != nil {
-					return fmt.Errorf("err get secret: %v", err)
+					return fmt.Errorf("err get secret: %w", err)
 				}
 				ref.ResourceVersion = sc.ResourceVersion
 			default:
@@ -160,117 +541,165 @@ func (t *DefaultTrigger) do(ctx context.Context, rule *appv1alpha1.TriggerRule)
 			return nil
 		})
 	}
-
 	if err := g.Wait(); err != nil {
-		return fmt.Errorf("err check sources: %v", err)
-	}
-
-	var actionG errgroup.Group
-	for i := range rule.Spec.Actions {
-		actionG.Go(func() error {
-			// rule will only be read in following process, it's ok to not make a copy
-			// TODO: retry
-			return t.action(ctx, rule, &rule.Spec.Actions[i])
-		})
+		return fmt.Errorf("err check sources: %w", err)
 	}
-	if err := actionG.Wait(); err != nil {
-		return fmt.Errorf("err execute actions: %v", err)
-	}
-
 	return nil
 }
 
-func (t *DefaultTrigger) action(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) error {
-	if action.UpdatePodTemplate != nil {
+// action executes the action at index of rule, a nil status is returned if nothing is done.
+func (t *DefaultTrigger) action(ctx context.Context, rule *appv1alpha1.TriggerRule, index int) (*appv1alpha1.ActionStatus, error) {
+	action := &rule.Spec.Actions[index]
+	if err := t.checkAction(rule, action); err != nil {
+		return nil, err
+	}
+	switch {
+	case action.UpdatePodTemplate != nil && action.UpdatePodTemplate.Selector != nil:
+		return t.updateSelectedTemplates(ctx, rule, index, action)
+	case action.UpdatePodTemplate != nil:
 		return t.updatePodTemplate(ctx, rule, action)
-	} else {
-		return fmt.Errorf("no action to execute")
+	case action.RunJob != nil:
+		return t.runJob(ctx, rule, index, action)
+	case action.Scale != nil:
+		return t.scale(ctx, rule, action)
+	case action.Patch != nil:
+		return t.patchObject(ctx, rule, index, action)
+	case action.Apply != nil:
+		return t.apply(ctx, rule, index, action)
+	case action.Flux != nil:
+		return t.fluxReconcile(ctx, rule, action)
+	case action.ArgoCD != nil:
+		return t.argoCD(ctx, rule, action)
+	case action.VersionedCopy != nil:
+		return t.versionedCopy(ctx, rule, action)
+	case action.Replicate != nil:
+		return t.replicate(ctx, rule, index, action)
+	case action.Render != nil:
+		return t.render(ctx, rule, index, action)
+	case action.RefreshVolumes != nil:
+		return t.refreshVolumes(ctx, rule, action)
+	case action.Auto != nil:
+		return t.auto(ctx, rule, action)
+	default:
+		return nil, fmt.Errorf("no action to execute")
 	}
 }
 
-func (t *DefaultTrigger) updatePodTemplate(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) error {
-	ref := action.UpdatePodTemplate.ObjectRef
+// updatePodTemplate submits a new record of the workload to be patched, without waiting for the patch. The
+// action is deferred until the batch of the record is patched, when the result is returned.
+func (t *DefaultTrigger) updatePodTemplate(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	ref := &action.UpdatePodTemplate.ObjectRef
 	annotationKey := GetRecordKey(rule.Name, rule.Namespace)
 
-	// TODO: refactor latter to reduce redundancy
-	switch ref.Kind {
-	case "Deployment":
-		d, err := t.client.AppsV1().Deployments(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("err get deployment: %v", err)
-		}
-
-		rec, err := t.generateNewRecord(rule, d.Spec.Template.Annotations, annotationKey)
-		if err != nil {
-			return fmt.Errorf("err generate record: %v", err)
-		}
-		if rec == nil {
-			return nil
-		}
-
-		pt, err := generatePatch(rec, annotationKey, d.Spec.Template.Annotations == nil)
-		if err != nil {
-			return fmt.Errorf("err generate patch: %v", err)
-		}
+	ri, mapping, err := t.resourceFor(ref)
+	if err != nil {
+		return nil, err
+	}
+	templatePath, err := podTemplatePath(mapping.GroupVersionKind.GroupKind(), action.UpdatePodTemplate.TemplatePath)
+	if err != nil {
+		return nil, err
+	}
 
-		t.logger.Info("Generate patch", "patch", string(pt))
-		if _, err := t.client.AppsV1().Deployments(d.Namespace).Patch(d.Name, types.JSONPatchType, pt); err != nil {
-			iErr := err.(*errors.StatusError)
-			return fmt.Errorf("err patch workload: %#v", iErr)
-		}
-		return nil
-	case "StatefulSet":
-		sts, err := t.client.AppsV1().StatefulSets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("err get statefulset: %v", err)
-		}
+	obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("err get %v: %w", mapping.GroupVersionKind.Kind, err)
+	}
+	if _, found, err := unstructured.NestedMap(obj.Object, templatePath...); err != nil || !found {
+		return nil, fmt.Errorf("pod template not found at %v in %v %s/%s", strings.Join(templatePath, "."), ref.Kind, ref.Namespace, ref.Name)
+	}
+	annotations, _, err := unstructured.NestedStringMap(obj.Object, append(templatePath, "metadata", "annotations")...)
+	if err != nil {
+		return nil, fmt.Errorf("err get annotations of pod template: %w", err)
+	}
 
-		rec, err := t.generateNewRecord(rule, sts.Spec.Template.Annotations, annotationKey)
-		if err != nil {
-			return fmt.Errorf("err generate record: %v", err)
-		}
-		if rec == nil {
-			return nil
-		}
+	rec, err := t.generateNewRecord(rule, annotations, annotationKey)
+	if err != nil {
+		return nil, fmt.Errorf("err generate record: %w", err)
+	}
 
-		pt, err := generatePatch(rec, annotationKey, sts.Spec.Template.Annotations == nil)
-		if err != nil {
-			return fmt.Errorf("err generate patch: %v", err)
+	key := mapping.Resource.GroupResource().String() + "/" + obj.GetNamespace() + "/" + obj.GetName()
+	ruleKey := types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}
+	if batch := t.targets.activeBatch(key, annotationKey, ruleKey, rec); batch != nil {
+		return t.waitingStatus(rule, ref, batch)
+	}
+	if batch := t.targets.takeResult(key, annotationKey); batch != nil && (batch.err != nil || rec == nil) {
+		return t.batchResult(rule, ref, annotationKey, batch)
+	}
+	if rec == nil {
+		return nil, nil
+	}
+	if cooldown := action.UpdatePodTemplate.Cooldown; cooldown != nil {
+		if until := cooldownUntil(annotations, cooldown.Duration); time.Now().Before(until) {
+			return &appv1alpha1.ActionStatus{
+				Phase:     appv1alpha1.ActionRunning,
+				Reason:    "CoolingDown",
+				Message:   fmt.Sprintf("rollout deferred until %v", until.Format(time.RFC3339)),
+				ObjectRef: ref.DeepCopy(),
+			}, &deferredError{until: until, reason: "cooldown"}
 		}
+	}
 
-		t.logger.Info("Generate patch", "patch", string(pt))
-		if _, err := t.client.AppsV1().StatefulSets(sts.Namespace).Patch(sts.Name, types.JSONPatchType, pt); err != nil {
-			iErr := err.(*errors.StatusError)
-			return fmt.Errorf("err patch workload: %#v", iErr)
-		}
-		return nil
-	case "DaemonSet":
-		ds, err := t.client.AppsV1().DaemonSets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("err get daemonset: %v", err)
-		}
+	batch := t.submitRecord(key, annotationKey, ruleKey, rec, &targetBatch{
+		ri:           ri,
+		name:         obj.GetName(),
+		templatePath: templatePath,
+		surge:        action.UpdatePodTemplate.Surge,
+		priority:     rule.Spec.Priority,
+	})
+	return t.waitingStatus(rule, ref, batch)
+}
 
-		rec, err := t.generateNewRecord(rule, ds.Spec.Template.Annotations, annotationKey)
-		if err != nil {
-			return fmt.Errorf("err generate record: %v", err)
-		}
-		if rec == nil {
-			return nil
-		}
+// waitingStatus returns the status of the record waiting in batch, and writes the position of the batch in the
+// rollout budget to status. The action is deferred, the rule is processed again once the batch is patched, or
+// earlier to refresh the position if the budget is enabled.
+func (t *DefaultTrigger) waitingStatus(rule *appv1alpha1.TriggerRule, ref *corev1.ObjectReference, batch *targetBatch) (*appv1alpha1.ActionStatus, error) {
+	status := &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionRunning,
+		Reason:    "RollingOut",
+		ObjectRef: ref.DeepCopy(),
+	}
+	position := t.budget.position(batch)
+	if position > 0 {
+		status.Reason = "Queued"
+		status.Message = fmt.Sprintf("waiting for rollout budget at position %d", position)
+	}
+	t.setQueued(rule, ref, position)
+	delay := defaultWaitTimeout
+	if t.budget.enabled() {
+		delay = queueStatusInterval
+	}
+	return status, &deferredError{until: time.Now().Add(delay), reason: "rollout"}
+}
 
-		pt, err := generatePatch(rec, annotationKey, ds.Spec.Template.Annotations == nil)
-		if err != nil {
-			return fmt.Errorf("err generate patch: %v", err)
-		}
+// batchResult returns the result of the record patched in batch.
+func (t *DefaultTrigger) batchResult(rule *appv1alpha1.TriggerRule, ref *corev1.ObjectReference, annotationKey string, batch *targetBatch) (*appv1alpha1.ActionStatus, error) {
+	t.setQueued(rule, ref, 0)
+	if batch.err != nil {
+		return &appv1alpha1.ActionStatus{
+			Phase:     appv1alpha1.ActionFailed,
+			Reason:    batch.reason,
+			Message:   batch.err.Error(),
+			ObjectRef: ref.DeepCopy(),
+		}, batch.err
+	}
+	return &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    batch.reason,
+		Message:   batchMessage(batch, annotationKey),
+		ObjectRef: ref.DeepCopy(),
+	}, nil
+}
 
-		t.logger.Info("Generate patch", "patch", string(pt))
-		if _, err := t.client.AppsV1().DaemonSets(ds.Namespace).Patch(ds.Name, types.JSONPatchType, pt); err != nil {
-			iErr := err.(*errors.StatusError)
-			return fmt.Errorf("err patch workload: %#v", iErr)
-		}
-		return nil
-	default:
-		return fmt.Errorf("unsupported workload kind %v", ref.Kind)
+// setQueued writes the position of the rollout of ref in the rollout budget to status, status is not read if the
+// rollout is neither queued nor was queued.
+func (t *DefaultTrigger) setQueued(rule *appv1alpha1.TriggerRule, ref *corev1.ObjectReference, position int) {
+	if position == 0 && len(rule.Status.Queue) == 0 {
+		return
+	}
+	if err := t.updateStatus(rule, func(s *appv1alpha1.TriggerRuleStatus) {
+		setQueuedRollout(&s.Queue, ref, position)
+	}); err != nil {
+		t.logger.Error(err, "Update status failed", "rule", rule.Name, "namespace", rule.Namespace)
 	}
 }
 
@@ -329,11 +758,29 @@ func (t *DefaultTrigger) generateNewRecord(rule *appv1alpha1.TriggerRule, annota
 		if !update {
 			return nil, nil
 		}
+		rec.LastUpdateTime = time.Now().UnixNano()
 		newRec = rec
 	}
 	return newRec, nil
 }
 
+// generateSpecRecord is like generateNewRecord, but a new record is also returned if spec, the hash of templates of
+// the action, differs from the spec of the record.
+func (t *DefaultTrigger) generateSpecRecord(rule *appv1alpha1.TriggerRule, annotations map[string]string, key string, spec string) (*Record, error) {
+	rec, err := t.generateNewRecord(rule, annotations, key)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		if rec, err = decodeRecordFromAnnotaion(annotations, key); err != nil || rec == nil || rec.Spec == spec {
+			return nil, err
+		}
+		rec.LastUpdateTime = time.Now().UnixNano()
+	}
+	rec.Spec = spec
+	return rec, nil
+}
+
 func decodeRecordFromAnnotaion(annotations map[string]string, key string) (*Record, error) {
 	if len(annotations) == 0 {
 		return nil, nil
@@ -346,7 +793,7 @@ func decodeRecordFromAnnotaion(annotations map[string]string, key string) (*Reco
 
 	ret := &Record{}
 	if err := json.Unmarshal([]byte(v), ret); err != nil {
-		return nil, fmt.Errorf("err decode annotaion <%v, %v>: %v", key, v, err)
+		return nil, fmt.Errorf("err decode annotaion <%v, %v>: %w", key, v, err)
 	}
 	return ret, nil
 }
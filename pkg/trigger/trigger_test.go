@@ -2,7 +2,15 @@ package trigger
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func TestGeneratePatch(t *testing.T) {
@@ -18,7 +26,7 @@ func TestGeneratePatch(t *testing.T) {
 		},
 	}
 	key := GetRecordKey("foo", "foo-ns")
-	pt, err := generatePatch(rec, key)
+	pt, err := generatePatch(rec, key, []string{"spec", "template"}, false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -35,5 +43,131 @@ func TestGeneratePatch(t *testing.T) {
 	}
 }
 
+func TestGeneratePatchEmptyAnnotations(t *testing.T) {
+	rec := &Record{LastUpdateTime: 1560210953900081130}
+	key := GetRecordKey("foo", "foo-ns")
+	pt, err := generatePatch(rec, key, []string{"spec", "jobTemplate", "spec", "template"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	any := AnySlice{}
+	if err := json.Unmarshal(pt, &any); err != nil {
+		t.Fatal(err)
+	}
+	if len(any) != 1 {
+		t.Fatal("Expect have length 1")
+	}
+	if path := any[0]["path"]; path != "/spec/jobTemplate/spec/template/metadata/annotations" {
+		t.Errorf("Unexpected path %v", path)
+	}
+	if _, ok := any[0]["value"].(map[string]interface{})[key]; !ok {
+		t.Errorf("Expect value contains key %v", key)
+	}
+}
+
+func TestGenerateRecordsPatch(t *testing.T) {
+	foo, bar := GetRecordKey("foo", "foo-ns"), GetRecordKey("bar", "foo-ns")
+	records := map[string]*Record{
+		foo: {LastUpdateTime: 1},
+		bar: {LastUpdateTime: 2},
+	}
+	pt, err := generateRecordsPatch(records, []string{"spec", "template"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	any := AnySlice{}
+	if err := json.Unmarshal(pt, &any); err != nil {
+		t.Fatal(err)
+	}
+	if len(any) != 2 {
+		t.Fatalf("Expect one operation for each record, got %v", string(pt))
+	}
+	// Operations are ordered by keys.
+	for i, key := range []string{bar, foo} {
+		if path := any[i]["path"]; !strings.HasSuffix(path.(string), escapeJSONPointerValue(key)) {
+			t.Errorf("Unexpected path %v", path)
+		}
+	}
+}
+
+func TestPodTemplatePath(t *testing.T) {
+	cases := []struct {
+		gk     schema.GroupKind
+		path   string
+		expect string
+	}{
+		{gk: schema.GroupKind{Group: "apps", Kind: "Deployment"}, expect: "spec.template"},
+		{gk: schema.GroupKind{Group: "batch", Kind: "CronJob"}, expect: "spec.jobTemplate.spec.template"},
+		{gk: schema.GroupKind{Group: "argoproj.io", Kind: "Rollout"}, expect: "spec.template"},
+		{gk: schema.GroupKind{Group: "example.com", Kind: "Foo"}, path: "{.spec.workload.template}", expect: "spec.workload.template"},
+	}
+	for _, c := range cases {
+		fields, err := podTemplatePath(c.gk, c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(fields, "."); got != c.expect {
+			t.Errorf("Expect %v, got %v", c.expect, got)
+		}
+	}
+
+	if _, err := podTemplatePath(schema.GroupKind{Kind: "Foo"}, ".spec.containers[0]"); err == nil {
+		t.Error("Expect error for unsupported path")
+	}
+}
+
 type AnySlice []Any
 type Any map[string]interface{}
+
+func TestAddMergesPendingRules(t *testing.T) {
+	tr := New(nil, nil, nil, nil, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+	key := types.NamespacedName{Namespace: "foo-ns", Name: "foo"}
+	for _, gen := range []int64{1, 2, 1} {
+		tr.Add(key, &appv1alpha1.TriggerRule{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns", Generation: gen}})
+	}
+	tr.Add(types.NamespacedName{Namespace: "foo-ns", Name: "bar"}, &appv1alpha1.TriggerRule{})
+
+	if tr.queue.Len() != 2 {
+		t.Errorf("expect 2 keys in queue, got %d", tr.queue.Len())
+	}
+	if gen := tr.rules[key].Generation; gen != 2 {
+		t.Errorf("expect the latest rule to be kept, got generation %v", gen)
+	}
+}
+
+func TestStopRejectsNewRules(t *testing.T) {
+	tr := New(nil, nil, nil, nil, nil, Options{DrainTimeout: time.Second}).(*DefaultTrigger)
+	tr.Add(types.NamespacedName{Namespace: "foo-ns", Name: "foo"}, &appv1alpha1.TriggerRule{})
+	tr.Stop()
+
+	tr.Add(types.NamespacedName{Namespace: "foo-ns", Name: "bar"}, &appv1alpha1.TriggerRule{})
+	if len(tr.rules) != 0 {
+		t.Errorf("expect queued rules handed off and new rules rejected, got %d rules", len(tr.rules))
+	}
+	if tr.ctx.Err() == nil {
+		t.Error("expect context canceled after stop")
+	}
+}
+
+func TestProcessNextBacksOffFailures(t *testing.T) {
+	tr := New(nil, nil, nil, nil, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+	key := types.NamespacedName{Namespace: "foo-ns", Name: "foo"}
+	rule := &appv1alpha1.TriggerRule{Spec: appv1alpha1.TriggerRuleSpec{Sources: []appv1alpha1.Source{
+		{ObjectRef: corev1.ObjectReference{Kind: "Foo", Name: "foo", Namespace: "foo-ns"}},
+	}}}
+	tr.Add(key, rule)
+	item, _ := tr.queue.Get()
+	tr.ready.push(item.(types.NamespacedName), 0)
+	tr.processNext()
+
+	if n := tr.queue.NumRequeues(key); n != 1 {
+		t.Errorf("expect failed rule requeued with backoff, got %d requeues", n)
+	}
+	if _, exist := tr.rules[key]; !exist {
+		t.Error("expect failed rule kept to be processed again")
+	}
+}
@@ -0,0 +1,183 @@
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	defaultVerifyContentTimeout = 120 * time.Second
+	verifyPollInterval          = 5 * time.Second
+)
+
+// verifyContent checks once whether pods see the current content of the source. Results of pods are returned,
+// and whether all of them converged.
+func (t *DefaultTrigger) verifyContent(rule *appv1alpha1.TriggerRule, pods []corev1.Pod, v *appv1alpha1.ContentVerification) ([]appv1alpha1.PodStatus, bool, error) {
+	if v.MountPath == "" && v.HTTPGet == nil {
+		return nil, false, fmt.Errorf("one of mountPath and httpGet must be set")
+	}
+	data, err := t.templateData(rule, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	src, err := data.Source(v.Source)
+	if err != nil {
+		return nil, false, err
+	}
+	keys := v.Keys
+	if len(keys) == 0 {
+		for k := range src.Data {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	expect, err := digest(src.Data, keys)
+	if err != nil {
+		return nil, false, err
+	}
+
+	converged := true
+	var results []appv1alpha1.PodStatus
+	for i := range pods {
+		result := t.verifyPod(&pods[i], v, keys, expect)
+		converged = converged && result.State == appv1alpha1.PodConverged
+		results = append(results, result)
+	}
+	return results, converged, nil
+}
+
+// verifyContentTimeout returns the maximum time to wait for pods to converge.
+func verifyContentTimeout(v *appv1alpha1.ContentVerification) time.Duration {
+	if v.TimeoutSeconds != nil {
+		return time.Duration(*v.TimeoutSeconds) * time.Second
+	}
+	return defaultVerifyContentTimeout
+}
+
+// verifyPod compares digest of the content seen by the pod with expect.
+func (t *DefaultTrigger) verifyPod(pod *corev1.Pod, v *appv1alpha1.ContentVerification, keys []string, expect string) appv1alpha1.PodStatus {
+	result := appv1alpha1.PodStatus{Name: pod.Name}
+	var (
+		actual string
+		err    error
+	)
+	if v.HTTPGet != nil {
+		actual, err = t.endpointDigest(pod, v.HTTPGet)
+	} else {
+		actual, err = t.execDigest(pod, v, keys)
+	}
+	switch {
+	case err != nil:
+		result.State = appv1alpha1.PodUnknown
+		result.Message = err.Error()
+	case actual == expect:
+		result.State = appv1alpha1.PodConverged
+	default:
+		result.State = appv1alpha1.PodStale
+		result.Message = fmt.Sprintf("digest %v, expect %v", actual, expect)
+	}
+	return result
+}
+
+// execDigest runs "cat" of the keys in the container and hashes the output.
+func (t *DefaultTrigger) execDigest(pod *corev1.Pod, v *appv1alpha1.ContentVerification, keys []string) (string, error) {
+	container := v.Container
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+	command := []string{"cat"}
+	for _, k := range keys {
+		command = append(command, path.Join(v.MountPath, k))
+	}
+
+	h := sha256.New()
+	var stderr bytes.Buffer
+	if err := t.execInPod(pod, container, command, h, &stderr); err != nil {
+		return "", fmt.Errorf("err exec in pod %v: %v, %s", pod.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// endpointDigest reads the digest from the endpoint of the pod through the API server proxy.
+func (t *DefaultTrigger) endpointDigest(pod *corev1.Pod, e *appv1alpha1.DigestEndpoint) (string, error) {
+	body, err := t.client.CoreV1().RESTClient().Get().
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name + ":" + strconv.Itoa(int(e.Port))).
+		SubResource("proxy").
+		Suffix(e.Path).
+		DoRaw()
+	if err != nil {
+		return "", fmt.Errorf("err get digest from pod %v: %w", pod.Name, err)
+	}
+	return strings.ToLower(strings.TrimSpace(string(body))), nil
+}
+
+// execInPod runs command in the container and writes its output to stdout and stderr.
+func (t *DefaultTrigger) execInPod(pod *corev1.Pod, container string, command []string, stdout, stderr io.Writer) error {
+	req := t.client.CoreV1().RESTClient().Post().
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(t.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+	return executor.Stream(remotecommand.StreamOptions{Stdout: stdout, Stderr: stderr})
+}
+
+// digest returns hex encoded sha256 of values of keys concatenated in order.
+func digest(data map[string]string, keys []string) (string, error) {
+	h := sha256.New()
+	for _, k := range keys {
+		v, ok := data[k]
+		if !ok {
+			return "", fmt.Errorf("key %v not found in source", k)
+		}
+		io.WriteString(h, v)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// countStale returns the number of pods not converged.
+func countStale(pods []appv1alpha1.PodStatus) int {
+	stale := 0
+	for _, p := range pods {
+		if p.State != appv1alpha1.PodConverged {
+			stale++
+		}
+	}
+	return stale
+}
+
+// restartWorkload triggers a rolling update of the workload, used when pods do not see the new content.
+func (t *DefaultTrigger) restartWorkload(ctx context.Context, rule *appv1alpha1.TriggerRule, ref *corev1.ObjectReference) error {
+	if ref == nil {
+		return fmt.Errorf("objectRef is required to restart stale pods")
+	}
+	_, err := t.updatePodTemplate(ctx, rule, &appv1alpha1.Action{
+		UpdatePodTemplate: &appv1alpha1.ActionUpdatePodTemplate{ObjectRef: *ref},
+	})
+	return err
+}
@@ -0,0 +1,22 @@
+package trigger
+
+import (
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	data := map[string]string{"a": "a", "b": "b", "c": "c"}
+
+	// Same as "cat a b | sha256sum".
+	d, err := digest(data, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "fb8e20fc2e4c3f248c60c39bd652f3c1347298bb977b8b4d5903b85055620603"; d != expect {
+		t.Errorf("Expect %v, got %v", expect, d)
+	}
+
+	if _, err := digest(data, []string{"a", "missing"}); err == nil {
+		t.Error("Expect error for missing key")
+	}
+}
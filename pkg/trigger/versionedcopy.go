@@ -0,0 +1,322 @@
+package trigger
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// CopyOfLabel is added to versioned copies of sources, value is name of the source.
+	CopyOfLabel = "trigger.app.example.com/copy-of"
+
+	contentHashLength = 10
+	maxNameLength     = 253
+)
+
+// referrerResources are resources searched for references of versioned copies before deleting them.
+var referrerResources = []schema.GroupVersionResource{
+	{Version: "v1", Resource: "pods"},
+	{Group: "apps", Version: "v1", Resource: "replicasets"},
+	{Group: "apps", Version: "v1", Resource: "controllerrevisions"},
+	{Group: "apps", Version: "v1", Resource: "deployments"},
+	{Group: "apps", Version: "v1", Resource: "statefulsets"},
+	{Group: "apps", Version: "v1", Resource: "daemonsets"},
+	{Group: "batch", Version: "v1beta1", Resource: "cronjobs"},
+}
+
+func (t *DefaultTrigger) versionedCopy(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.VersionedCopy
+	ref := &spec.ObjectRef
+
+	ri, mapping, err := t.resourceFor(ref)
+	if err != nil {
+		return nil, err
+	}
+	templatePath, err := podTemplatePath(mapping.GroupVersionKind.GroupKind(), spec.TemplatePath)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("err get %v: %w", mapping.GroupVersionKind.Kind, err)
+	}
+	specPath := append(append([]string{}, templatePath...), "spec")
+	podSpec, found, err := unstructured.NestedMap(obj.Object, specPath...)
+	if err != nil || !found {
+		return nil, fmt.Errorf("pod template not found at %v in %v %s/%s", strings.Join(templatePath, "."), ref.Kind, ref.Namespace, ref.Name)
+	}
+
+	renames := map[string]map[string]string{}
+	var copies []corev1.ObjectReference
+	for _, src := range rule.Spec.Sources {
+		src := src.ObjectRef
+		if src.Namespace != obj.GetNamespace() {
+			continue
+		}
+		copyName, err := t.ensureCopy(&src)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := t.listCopies(&src)
+		if err != nil {
+			return nil, err
+		}
+		if renames[src.Kind] == nil {
+			renames[src.Kind] = map[string]string{}
+		}
+		renames[src.Kind][src.Name] = copyName
+		for _, c := range existing {
+			renames[src.Kind][c.GetName()] = copyName
+		}
+		copies = append(copies, corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       src.Kind,
+			Namespace:  src.Namespace,
+			Name:       copyName,
+		})
+	}
+
+	changed := rewriteReferences(podSpec, renames)
+	if changed {
+		// Test resourceVersion so references updated by others in the meantime are not overwritten.
+		pt, err := json.Marshal([]map[string]interface{}{
+			{"op": "test", "path": "/metadata/resourceVersion", "value": obj.GetResourceVersion()},
+			{"op": "replace", "path": jsonPointer(specPath), "value": podSpec},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("err generate patch: %w", err)
+		}
+		t.logger.Info("Update references to versioned copies", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name)
+		if _, err := ri.Patch(obj.GetName(), types.JSONPatchType, pt, metav1.UpdateOptions{}); err != nil {
+			return nil, fmt.Errorf("err patch workload: %w", err)
+		}
+	}
+
+	// Copies are also collected when nothing changed, since old revisions are removed asynchronously.
+	keep := map[string]bool{}
+	for _, c := range copies {
+		keep[c.Name] = true
+	}
+	collected, err := t.collectCopies(rule, obj.GetNamespace(), keep)
+	if err != nil {
+		if !changed {
+			return nil, err
+		}
+		return &appv1alpha1.ActionStatus{
+			Phase:     appv1alpha1.ActionFailed,
+			Reason:    "CollectFailed",
+			Message:   err.Error(),
+			ObjectRef: ref.DeepCopy(),
+			Objects:   copies,
+		}, err
+	}
+	if !changed {
+		return nil, nil
+	}
+	return &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    "ReferencesUpdated",
+		Message:   fmt.Sprintf("%d copies referenced, %d unreferenced copies deleted", len(copies), collected),
+		ObjectRef: ref.DeepCopy(),
+		Objects:   copies,
+	}, nil
+}
+
+// ensureCopy creates an immutable copy of the source if it does not exist, and returns name of the copy.
+func (t *DefaultTrigger) ensureCopy(src *corev1.ObjectReference) (string, error) {
+	if src.Kind != "ConfigMap" && src.Kind != "Secret" {
+		return "", fmt.Errorf("unsupported source kind %v", src.Kind)
+	}
+	ri, _, err := t.resourceFor(src)
+	if err != nil {
+		return "", err
+	}
+	obj, err := ri.Get(src.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("err get %v %s/%s: %w", src.Kind, src.Namespace, src.Name, err)
+	}
+	hash, err := contentHash(obj)
+	if err != nil {
+		return "", err
+	}
+	name := copyName(src.Name, hash)
+
+	lbs := map[string]string{}
+	for k, v := range obj.GetLabels() {
+		lbs[k] = v
+	}
+	lbs[CopyOfLabel] = src.Name
+	cp := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	for _, field := range []string{"data", "binaryData", "type"} {
+		if v, ok := obj.Object[field]; ok {
+			cp.Object[field] = v
+		}
+	}
+	cp.Object["immutable"] = true
+	cp.SetAPIVersion(obj.GetAPIVersion())
+	cp.SetKind(obj.GetKind())
+	cp.SetNamespace(src.Namespace)
+	cp.SetName(name)
+	cp.SetLabels(lbs)
+
+	_, err = ri.Create(cp, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return name, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("err create copy of %v %s/%s: %w", src.Kind, src.Namespace, src.Name, err)
+	}
+	t.logger.Info("Create versioned copy", "kind", src.Kind, "namespace", src.Namespace, "name", name)
+	return name, nil
+}
+
+// listCopies lists versioned copies of the source.
+func (t *DefaultTrigger) listCopies(src *corev1.ObjectReference) ([]unstructured.Unstructured, error) {
+	ri, _, err := t.resourceFor(src)
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(map[string]string{CopyOfLabel: src.Name})
+	list, err := ri.List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("err list copies of %v %s/%s: %w", src.Kind, src.Namespace, src.Name, err)
+	}
+	return list.Items, nil
+}
+
+// collectCopies deletes copies of sources of rule in namespace, which are neither in keep nor referenced by any
+// object in referrerResources.
+func (t *DefaultTrigger) collectCopies(rule *appv1alpha1.TriggerRule, namespace string, keep map[string]bool) (int, error) {
+	var referenced map[string]bool
+	deleted := 0
+	for _, src := range rule.Spec.Sources {
+		src := src.ObjectRef
+		if src.Namespace != namespace {
+			continue
+		}
+		existing, err := t.listCopies(&src)
+		if err != nil {
+			return deleted, err
+		}
+		for _, c := range existing {
+			if keep[c.GetName()] {
+				continue
+			}
+			if referenced == nil {
+				if referenced, err = t.referencedNames(namespace); err != nil {
+					return deleted, err
+				}
+			}
+			if referenced[c.GetName()] {
+				continue
+			}
+			ri, _, err := t.resourceFor(&src)
+			if err != nil {
+				return deleted, err
+			}
+			t.logger.Info("Delete unreferenced copy", "kind", src.Kind, "namespace", namespace, "name", c.GetName())
+			if err := ri.Delete(c.GetName(), &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return deleted, fmt.Errorf("err delete %v %s/%s: %w", src.Kind, namespace, c.GetName(), err)
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// referencedNames returns all strings in objects of referrerResources in namespace. It is a superset of names
+// of ConfigMaps and Secrets referenced by them.
+func (t *DefaultTrigger) referencedNames(namespace string) (map[string]bool, error) {
+	names := map[string]bool{}
+	for _, gvr := range referrerResources {
+		list, err := t.dynamic.Resource(gvr).Namespace(namespace).List(metav1.ListOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("err list %v: %w", gvr.Resource, err)
+		}
+		for _, item := range list.Items {
+			collectStrings(item.Object["spec"], names)
+			collectStrings(item.Object["data"], names)
+		}
+	}
+	return names, nil
+}
+
+// collectStrings adds all string values in v to set.
+func collectStrings(v interface{}, set map[string]bool) {
+	switch v := v.(type) {
+	case string:
+		set[v] = true
+	case map[string]interface{}:
+		for _, e := range v {
+			collectStrings(e, set)
+		}
+	case []interface{}:
+		for _, e := range v {
+			collectStrings(e, set)
+		}
+	}
+}
+
+// contentHash returns a short hash of the content of a ConfigMap or Secret.
+func contentHash(obj *unstructured.Unstructured) (string, error) {
+	content := map[string]interface{}{}
+	for _, field := range []string{"data", "binaryData", "type"} {
+		if v, ok := obj.Object[field]; ok {
+			content[field] = v
+		}
+	}
+	// Keys of maps are sorted by json.Marshal, so the encoding is stable.
+	b, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("err encode content of %v: %w", obj.GetName(), err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:contentHashLength], nil
+}
+
+// copyName returns name of the copy of source name with hash.
+func copyName(name, hash string) string {
+	if max := maxNameLength - len(hash) - 1; len(name) > max {
+		name = name[:max]
+	}
+	return name + "-" + hash
+}
+
+// rewriteReferences renames ConfigMaps and Secrets referenced in volumes, projected volumes, envFrom and env of
+// podSpec, renames are keyed by kind then old name. It returns true if any reference is changed.
+func rewriteReferences(podSpec map[string]interface{}, renames map[string]map[string]string) bool {
+	changed := false
+	visitReferences(podSpec, func(ref *sourceReference) {
+		if newName, ok := renames[ref.Kind][ref.Name]; ok && newName != ref.Name {
+			ref.set(newName)
+			changed = true
+		}
+	})
+	return changed
+}
+
+// jsonPointer returns JSON Pointer of fields.
+func jsonPointer(fields []string) string {
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString("/")
+		b.WriteString(escapeJSONPointerValue(f))
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package trigger
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRewriteReferences(t *testing.T) {
+	podSpec := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{
+  "volumes": [
+    {"name": "a", "configMap": {"name": "cm"}},
+    {"name": "b", "secret": {"secretName": "cm"}},
+    {"name": "c", "projected": {"sources": [{"configMap": {"name": "cm-0123456789"}}, {"secret": {"name": "sc"}}]}}
+  ],
+  "containers": [{
+    "name": "app",
+    "envFrom": [{"configMapRef": {"name": "cm"}}, {"secretRef": {"name": "other"}}],
+    "env": [{"name": "X", "valueFrom": {"secretKeyRef": {"name": "sc", "key": "x"}}}, {"name": "Y", "value": "cm"}]
+  }]
+}`), &podSpec); err != nil {
+		t.Fatal(err)
+	}
+	renames := map[string]map[string]string{
+		"ConfigMap": {"cm": "cm-abcdef0123", "cm-0123456789": "cm-abcdef0123"},
+		"Secret":    {"sc": "sc-abcdef0123"},
+	}
+	if !rewriteReferences(podSpec, renames) {
+		t.Fatal("expect references changed")
+	}
+	expected := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{
+  "volumes": [
+    {"name": "a", "configMap": {"name": "cm-abcdef0123"}},
+    {"name": "b", "secret": {"secretName": "cm"}},
+    {"name": "c", "projected": {"sources": [{"configMap": {"name": "cm-abcdef0123"}}, {"secret": {"name": "sc-abcdef0123"}}]}}
+  ],
+  "containers": [{
+    "name": "app",
+    "envFrom": [{"configMapRef": {"name": "cm-abcdef0123"}}, {"secretRef": {"name": "other"}}],
+    "env": [{"name": "X", "valueFrom": {"secretKeyRef": {"name": "sc-abcdef0123", "key": "x"}}}, {"name": "Y", "value": "cm"}]
+  }]
+}`), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(podSpec, expected) {
+		t.Errorf("unexpected pod spec %v", podSpec)
+	}
+	if rewriteReferences(podSpec, renames) {
+		t.Error("expect references unchanged")
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	a := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "a", "resourceVersion": "1"},
+		"data":     map[string]interface{}{"x": "1", "y": "2"},
+	}}
+	b := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "b", "resourceVersion": "2"},
+		"data":     map[string]interface{}{"y": "2", "x": "1"},
+	}}
+	ha, err := contentHash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := contentHash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ha != hb || len(ha) != contentHashLength {
+		t.Errorf("expect same hash for same content, got %v and %v", ha, hb)
+	}
+	b.Object["data"] = map[string]interface{}{"x": "1", "y": "3"}
+	if hb, _ = contentHash(b); ha == hb {
+		t.Errorf("expect different hash for different content, got %v", hb)
+	}
+}
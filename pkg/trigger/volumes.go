@@ -0,0 +1,314 @@
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// VolumeRefreshAnnotation is set on pods to make kubelet sync their volumes, value is the time of the request.
+	VolumeRefreshAnnotation = "trigger.app.example.com/volume-refresh"
+
+	defaultVerifyDelay = 10 * time.Second
+)
+
+// verification is the pending verification of pods refreshed for a version of sources. Pods are checked when the
+// rule is processed again, so workers are not held while pods converge.
+type verification struct {
+	hash string
+	pods []corev1.Pod
+	// notBefore is when pods are checked next, deadline is when waiting for content to converge ends.
+	notBefore time.Time
+	deadline  time.Time
+	// healthy is set once pods are checked to be still ready and not restarted.
+	healthy bool
+}
+
+// refreshVolumes annotates target pods. Any update of a pod makes kubelet reprocess the pod, including syncing
+// its ConfigMap and Secret volumes. The record is kept in annotations of each pod, so a pod is only annotated once
+// for each version of sources. Refreshed pods are verified later, the action is deferred until then.
+func (t *DefaultTrigger) refreshVolumes(ctx context.Context, rule *appv1alpha1.TriggerRule, action *appv1alpha1.Action) (*appv1alpha1.ActionStatus, error) {
+	spec := action.RefreshVolumes
+	pods, err := t.targetPods(rule, spec.ObjectRef, spec.Selector, spec.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	annotationKey := GetRecordKey(rule.Name, rule.Namespace)
+	requestedAt := time.Now().Format(time.RFC3339Nano)
+	var refreshed []corev1.Pod
+	var objects []corev1.ObjectReference
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		rec, err := t.generateNewRecord(rule, pod.Annotations, annotationKey)
+		if err != nil {
+			return nil, fmt.Errorf("err generate record: %w", err)
+		}
+		if rec == nil {
+			continue
+		}
+		ri, _, err := t.resourceFor(&corev1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace})
+		if err != nil {
+			return nil, err
+		}
+		if err := t.patchRecord(ri, rule, pod.Name, rec, map[string]interface{}{
+			VolumeRefreshAnnotation: requestedAt,
+		}); err != nil {
+			return &appv1alpha1.ActionStatus{
+				Phase:   appv1alpha1.ActionFailed,
+				Reason:  "RefreshFailed",
+				Message: err.Error(),
+				Objects: objects,
+			}, err
+		}
+		refreshed = append(refreshed, pod)
+		objects = append(objects, podReference(&pod))
+	}
+
+	key := verificationKey(rule, spec)
+	hash := sourcesHash(rule)
+	t.mu.Lock()
+	v := t.verifications[key]
+	if v != nil && v.hash != hash {
+		// Sources changed, pods are refreshed and verified again.
+		delete(t.verifications, key)
+		v = nil
+	}
+	t.mu.Unlock()
+
+	if len(refreshed) > 0 {
+		t.logger.Info("Refresh volumes of pods", "count", len(refreshed), "rule", rule.Name, "namespace", rule.Namespace)
+		status := &appv1alpha1.ActionStatus{
+			Phase:     appv1alpha1.ActionSucceeded,
+			Reason:    "VolumesRefreshed",
+			Message:   fmt.Sprintf("%d pods annotated", len(refreshed)),
+			ObjectRef: spec.ObjectRef.DeepCopy(),
+			Objects:   objects,
+		}
+		if !spec.Verify && spec.VerifyContent == nil {
+			return status, nil
+		}
+
+		now := time.Now()
+		if v == nil {
+			v = &verification{hash: hash}
+		}
+		v.pods = append(v.pods, refreshed...)
+		v.notBefore, v.healthy = now, false
+		if spec.Verify {
+			delay := defaultVerifyDelay
+			if spec.VerifyDelaySeconds != nil {
+				delay = time.Duration(*spec.VerifyDelaySeconds) * time.Second
+			}
+			v.notBefore = now.Add(delay)
+		}
+		if spec.VerifyContent != nil {
+			v.deadline = v.notBefore.Add(verifyContentTimeout(spec.VerifyContent))
+		}
+		t.mu.Lock()
+		t.verifications[key] = v
+		t.mu.Unlock()
+
+		status.Phase = appv1alpha1.ActionRunning
+		status.Reason = "Verifying"
+		status.Message = fmt.Sprintf("%d pods annotated, verifying", len(v.pods))
+		return status, &deferredError{until: v.notBefore, reason: "verification of refreshed pods"}
+	}
+	if v == nil {
+		return nil, nil
+	}
+	if time.Now().Before(v.notBefore) {
+		return nil, &deferredError{until: v.notBefore, reason: "verification of refreshed pods"}
+	}
+	return t.verifyRefreshed(ctx, rule, spec, key, v)
+}
+
+// verifyRefreshed checks pods of the pending verification v. The verification is forgotten once it is done,
+// waiting for content to converge is deferred until pods are checked again.
+func (t *DefaultTrigger) verifyRefreshed(ctx context.Context, rule *appv1alpha1.TriggerRule, spec *appv1alpha1.ActionRefreshVolumes, key string, v *verification) (*appv1alpha1.ActionStatus, error) {
+	var objects []corev1.ObjectReference
+	for i := range v.pods {
+		objects = append(objects, podReference(&v.pods[i]))
+	}
+	status := &appv1alpha1.ActionStatus{
+		Phase:     appv1alpha1.ActionSucceeded,
+		Reason:    "VolumesRefreshed",
+		Message:   fmt.Sprintf("%d pods annotated", len(v.pods)),
+		ObjectRef: spec.ObjectRef.DeepCopy(),
+		Objects:   objects,
+	}
+	done := func() {
+		t.mu.Lock()
+		delete(t.verifications, key)
+		t.mu.Unlock()
+	}
+
+	if spec.Verify {
+		if !v.healthy {
+			if unhealthy := t.unhealthyPods(v.pods); len(unhealthy) > 0 {
+				done()
+				status.Phase = appv1alpha1.ActionFailed
+				status.Reason = "VerifyFailed"
+				status.Message = fmt.Sprintf("pods restarted or not ready after refresh: %v", strings.Join(unhealthy, ", "))
+				return status, fmt.Errorf("%v", status.Message)
+			}
+			v.healthy = true
+		}
+		status.Reason = "VolumesRefreshedVerified"
+	}
+
+	c := spec.VerifyContent
+	if c == nil {
+		done()
+		return status, nil
+	}
+	pods, converged, err := t.verifyContent(rule, v.pods, c)
+	if err != nil {
+		done()
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "VerifyFailed"
+		status.Message = err.Error()
+		return status, err
+	}
+	if !converged && time.Now().Before(v.deadline) {
+		v.notBefore = time.Now().Add(verifyPollInterval)
+		return nil, &deferredError{until: v.notBefore, reason: "convergence of refreshed pods"}
+	}
+	done()
+	status.Pods = pods
+	stale := countStale(status.Pods)
+	if stale == 0 {
+		status.Reason = "Converged"
+		status.Message = fmt.Sprintf("%d pods converged", len(status.Pods))
+		return status, nil
+	}
+	status.Message = fmt.Sprintf("%d of %d pods stale", stale, len(status.Pods))
+	if !c.RestartStale {
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "Stale"
+		return status, fmt.Errorf("%v", status.Message)
+	}
+	t.logger.Info("Restart workload with stale pods", "rule", rule.Name, "namespace", rule.Namespace, "stale", stale)
+	if err := t.restartWorkload(ctx, rule, spec.ObjectRef); err != nil {
+		if _, ok := isDeferred(err); ok {
+			// The rolling update is in progress, pods are verified again once it is done.
+			status.Phase = appv1alpha1.ActionRunning
+			status.Reason = "StaleRestarting"
+			status.Message += ", rolling update triggered"
+			return status, err
+		}
+		status.Phase = appv1alpha1.ActionFailed
+		status.Reason = "RestartFailed"
+		status.Message = err.Error()
+		return status, err
+	}
+	status.Reason = "StaleRestarted"
+	status.Message += ", rolling update triggered"
+	return status, nil
+}
+
+// verificationKey identifies the pods refreshed by spec of rule.
+func verificationKey(rule *appv1alpha1.TriggerRule, spec *appv1alpha1.ActionRefreshVolumes) string {
+	target := spec.Namespace + "/" + metav1.FormatLabelSelector(spec.Selector)
+	if ref := spec.ObjectRef; ref != nil {
+		target = ref.Kind + "/" + ref.Namespace + "/" + ref.Name
+	}
+	return rule.Namespace + "/" + rule.Name + "/" + target
+}
+
+// unhealthyPods returns names of pods which are gone, not ready, or have containers restarted since before.
+func (t *DefaultTrigger) unhealthyPods(before []corev1.Pod) []string {
+	var unhealthy []string
+	for i := range before {
+		old := &before[i]
+		pod, err := t.client.CoreV1().Pods(old.Namespace).Get(old.Name, metav1.GetOptions{})
+		if err != nil || pod.UID != old.UID || !podReady(pod) || restartCount(pod) != restartCount(old) {
+			unhealthy = append(unhealthy, old.Name)
+		}
+	}
+	return unhealthy
+}
+
+// targetPods returns pods matching spec.selector of the workload ref, or selector in namespace if ref is nil.
+func (t *DefaultTrigger) targetPods(rule *appv1alpha1.TriggerRule, ref *corev1.ObjectReference, selector *metav1.LabelSelector, namespace string) ([]corev1.Pod, error) {
+	if namespace == "" {
+		namespace = rule.Namespace
+	}
+	switch {
+	case ref != nil:
+		ri, mapping, err := t.resourceFor(ref)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("err get %v: %w", mapping.GroupVersionKind.Kind, err)
+		}
+		if selector, err = workloadSelector(obj); err != nil {
+			return nil, err
+		}
+		namespace = obj.GetNamespace()
+	case selector == nil:
+		return nil, fmt.Errorf("one of objectRef and selector must be set")
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("err parse selector: %w", err)
+	}
+	list, err := t.client.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: s.String()})
+	if err != nil {
+		return nil, fmt.Errorf("err list pods: %w", err)
+	}
+	return list.Items, nil
+}
+
+// workloadSelector returns spec.selector of the workload.
+func workloadSelector(obj *unstructured.Unstructured) (*metav1.LabelSelector, error) {
+	m, found, err := unstructured.NestedMap(obj.Object, "spec", "selector")
+	if err != nil || !found {
+		return nil, fmt.Errorf("selector not found in %v %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+	}
+	selector := &metav1.LabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, selector); err != nil {
+		return nil, fmt.Errorf("err decode selector of %v %s/%s: %w", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+	}
+	return selector, nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func restartCount(pod *corev1.Pod) int32 {
+	var count int32
+	for _, s := range pod.Status.ContainerStatuses {
+		count += s.RestartCount
+	}
+	return count
+}
+
+func podReference(pod *corev1.Pod) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
@@ -0,0 +1,63 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/caitong93/kube-trigger/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRefreshVolumesVerifiedLater(t *testing.T) {
+	rec, _ := json.Marshal(&Record{Sources: []Source{{Name: "foo", Namespace: "foo-ns", Kind: "ConfigMap", ResourceVersion: "1"}}})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "foo-0",
+			Namespace:   "foo-ns",
+			Labels:      map[string]string{"app": "foo"},
+			Annotations: map[string]string{GetRecordKey("foo", "foo-ns"): string(rec)},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	tr := New(nil, fake.NewSimpleClientset(pod), nil, nil, nil, Options{}).(*DefaultTrigger)
+	defer tr.Stop()
+	rule := &appv1alpha1.TriggerRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "foo-ns"},
+		Spec: appv1alpha1.TriggerRuleSpec{
+			Sources: []appv1alpha1.Source{{ObjectRef: corev1.ObjectReference{Kind: "ConfigMap", Name: "foo", Namespace: "foo-ns", ResourceVersion: "1"}}},
+			Actions: []appv1alpha1.Action{{RefreshVolumes: &appv1alpha1.ActionRefreshVolumes{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}},
+				Verify:   true,
+			}}},
+		},
+	}
+	action := &rule.Spec.Actions[0]
+
+	// The pod was refreshed for the current sources, and is verified after the delay.
+	key := verificationKey(rule, action.RefreshVolumes)
+	v := &verification{hash: sourcesHash(rule), pods: []corev1.Pod{*pod}, notBefore: time.Now().Add(time.Hour)}
+	tr.verifications[key] = v
+	status, err := tr.refreshVolumes(context.Background(), rule, action)
+	if _, ok := isDeferred(err); !ok || status != nil {
+		t.Fatalf("expect verification deferred, got %#v, %v", status, err)
+	}
+
+	v.notBefore = time.Now()
+	status, err = tr.refreshVolumes(context.Background(), rule, action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Reason != "VolumesRefreshedVerified" || len(status.Objects) != 1 {
+		t.Fatalf("expect pods verified, got %#v", status)
+	}
+	if status, err := tr.refreshVolumes(context.Background(), rule, action); status != nil || err != nil {
+		t.Errorf("expect nothing done once verified, got %#v, %v", status, err)
+	}
+}